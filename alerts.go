@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"barcode-pos/tsplprinter"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Printer feedback events.
+const (
+	EventJobHeld        = "job_held"        // an attempt failed and the job waits in the queue for a retry
+	EventJobFailed      = "job_failed"      // a job gave up after its last attempt
+	EventLabelNarrowed  = "label_narrowed"  // the job's labels are wider than the printer's descriptor allows and were narrowed
+	EventMaintenanceDue = "maintenance_due" // the head-wear threshold was reached
+)
+
+var alertEvents = []string{EventJobHeld, EventJobFailed, EventLabelNarrowed, EventMaintenanceDue}
+
+const MaxAlertRepeat = 10
+
+// AlertConfig is the beep pattern played on the printer for an event.
+type AlertConfig struct {
+	Level    int `json:"level"`
+	Interval int `json:"interval"` // ms per beep
+	Repeat   int `json:"repeat"`
+}
+
+func (a AlertConfig) validate() error {
+	if a.Level < 0 || a.Level > 9 {
+		return errors.New("level must be between 0 and 9")
+	}
+	if a.Interval < 1 || a.Interval > 4095 {
+		return errors.New("interval must be between 1 and 4095")
+	}
+	if a.Repeat < 0 || a.Repeat > MaxAlertRepeat {
+		return fmt.Errorf("repeat must be between 0 and %d", MaxAlertRepeat)
+	}
+	return nil
+}
+
+// signalEvent plays the configured pattern for the event on the printer, if
+// any. A print in progress on the printer finishes first.
+func signalEvent(event, vid, pid string) {
+	a, ok := currentConfig().Alerts[event]
+	if !ok {
+		return
+	}
+	if err := tsplprinter.Sound(vid, pid, a.Level, a.Interval, a.Repeat); err != nil {
+		log.Printf("Printer %s: %s alert failed: %v", tsplprinter.PrinterName(vid, pid), event, err)
+	}
+}
+
+// printerAlertHandler beeps the printer on demand, e.g. to find it on the floor.
+func printerAlertHandler(c echo.Context) error {
+	vid, pid, ok := tsplprinter.ParsePrinterName(c.Param("name"))
+	if !ok {
+		return apiError(c, http.StatusBadRequest, ErrInvalidPrinterName, "printer name must be vid:pid in hex, e.g. 0fe6:8800")
+	}
+	a := AlertConfig{Level: 5, Interval: 200, Repeat: 1}
+	if err := c.Bind(&a); err != nil {
+		return apiError(c, http.StatusBadRequest, ErrInvalidJSON, "Invalid JSON")
+	}
+	if err := a.validate(); err != nil {
+		return apiErrorFrom(c, ErrInvalidRequest, err)
+	}
+	if err := tsplprinter.Sound(vid, pid, a.Level, a.Interval, a.Repeat); err != nil {
+		return apiError(c, http.StatusBadRequest, ErrPrinterOffline, fmt.Sprintf("Printer device not found, please check connected or not: %s", err))
+	}
+	return c.JSON(http.StatusOK, echo.Map{"status": "sent"})
+}
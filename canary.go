@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+
+	"barcode-pos/preview"
+	"barcode-pos/tsplprinter"
+)
+
+// Canary modes.
+const (
+	CanaryMirror = "mirror" // print as usual and also capture the output
+	CanaryDivert = "divert" // capture the output instead of printing
+)
+
+const DefaultCanaryDir = "canary"
+
+// CanaryDivertedWarning is stored as (part of) the warning of diverted jobs,
+// which end up done without a label printed.
+const CanaryDivertedWarning = "diverted to canary, not printed"
+
+// CanaryConfig captures, for Percent of jobs, the printer program a job
+// sends (job-<id>.tspl) and a preview image of each distinct label
+// (job-<id>-<n>.png) in OutputDir, alongside printing or instead of it. Use
+// it to check template or driver changes against production traffic;
+// diverted jobs use no label stock and report CanaryDivertedWarning in job
+// status, listings and post hooks. Jobs are picked by ID, so a job keeps
+// its mode across retries. With a key file the captures are encrypted like
+// job payloads (".enc" appended); read one with
+// "barcode-pos canary-open <file>". Erasing a subject deletes its jobs'
+// captures.
+type CanaryConfig struct {
+	Percent   int    `json:"percent"` // 0-100, 0 disables canary mode
+	Mode      string `json:"mode"`    // CanaryMirror or CanaryDivert; empty means mirror
+	OutputDir string `json:"outputDir"`
+}
+
+func (cc *CanaryConfig) validate() error {
+	if cc.Percent < 0 || cc.Percent > 100 {
+		return errors.New("percent must be between 0 and 100")
+	}
+	if cc.Mode != "" && cc.Mode != CanaryMirror && cc.Mode != CanaryDivert {
+		return fmt.Errorf("mode must be %q or %q", CanaryMirror, CanaryDivert)
+	}
+	return nil
+}
+
+// canaryMode returns the canary mode for a job, or "" if it only prints.
+func canaryMode(job *Job) string {
+	cc := currentConfig().Canary
+	if cc.Percent <= 0 || job.ID%100 >= cc.Percent {
+		return ""
+	}
+	if cc.Mode == "" {
+		return CanaryMirror
+	}
+	return cc.Mode
+}
+
+func canaryDir() string {
+	if dir := currentConfig().Canary.OutputDir; dir != "" {
+		return dir
+	}
+	return DefaultCanaryDir
+}
+
+// runCanary captures a job's output in the canary directory. labels are the
+// job's expanded labels; raw jobs are captured as sent.
+func runCanary(job *Job, labels []tsplprinter.Label) error {
+	dir := canaryDir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	ext := "tspl"
+	program := job.Request.RawData
+	if job.Request.RawFormat != "" {
+		ext = job.Request.RawFormat
+	} else {
+		var buf bytes.Buffer
+		if err := tsplprinter.WriteLabels(&buf, labels); err != nil {
+			return err
+		}
+		program = buf.Bytes()
+	}
+	if err := writeCapture(filepath.Join(dir, fmt.Sprintf("job-%d.%s", job.ID, ext)), program); err != nil {
+		return err
+	}
+
+	// One preview per set member; the rest of the run repeats them
+	members := labels[:min(len(labels), 1+len(job.Request.Set))]
+	if len(members) > 0 {
+		if err := validateLabelSize(job.Request.SizeX, job.Request.SizeY); err != nil {
+			return fmt.Errorf("no preview: %w", err)
+		}
+	}
+	for i, l := range members {
+		var img bytes.Buffer
+		if err := png.Encode(&img, preview.Render(tsplprinter.LabelLayout(l))); err != nil {
+			return err
+		}
+		if err := writeCapture(filepath.Join(dir, fmt.Sprintf("job-%d-%d.png", job.ID, i)), img.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCapture writes one capture file, sealed with the payload key if one
+// is loaded so captures don't leak what the jobs table keeps encrypted.
+func writeCapture(path string, data []byte) error {
+	if payloadAEAD != nil {
+		sealed, err := sealBytes(data)
+		if err != nil {
+			return err
+		}
+		path, data = path+".enc", sealed
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// removeCanaryCaptures deletes the captures of a job, if it has any.
+func removeCanaryCaptures(jobID int) error {
+	dir := canaryDir()
+	for _, pattern := range []string{"job-%d.*", "job-%d-*"} {
+		paths, _ := filepath.Glob(filepath.Join(dir, fmt.Sprintf(pattern, jobID)))
+		for _, path := range paths {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// runCanaryOpen decrypts an encrypted capture to stdout:
+// barcode-pos canary-open <file>. It needs the key file in the working
+// directory.
+func runCanaryOpen(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: barcode-pos canary-open <file>")
+		return 2
+	}
+	if err := loadPayloadKey(); err != nil {
+		fmt.Fprintf(os.Stderr, "Key load error: %v\n", err)
+		return 1
+	}
+	if payloadAEAD == nil {
+		fmt.Fprintf(os.Stderr, "no key file %s\n", KeyFilePath)
+		return 1
+	}
+	sealed, err := os.ReadFile(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	plain, err := openBytes(sealed)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "decrypt %s: %v\n", args[0], err)
+		return 1
+	}
+	os.Stdout.Write(plain)
+	return 0
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCanaryCapturesSealedAndErased(t *testing.T) {
+	dir := t.TempDir()
+	config.Store(&Config{Canary: CanaryConfig{OutputDir: dir}})
+	t.Cleanup(func() { config.Store(nil) })
+
+	block, _ := aes.NewCipher(bytes.Repeat([]byte{7}, 32))
+	aead, _ := cipher.NewGCM(block)
+	payloadAEAD = aead
+	t.Cleanup(func() { payloadAEAD = nil })
+
+	program := []byte("TEXT 10,10,\"2\",0,1,1,\"Jane Doe\"\r\nPRINT 1,1\r\n")
+	for _, name := range []string{"job-12.tspl", "job-12-0.png", "job-123.tspl"} {
+		if err := writeCapture(filepath.Join(dir, name), program); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sealed, err := os.ReadFile(filepath.Join(dir, "job-12.tspl.enc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("Jane Doe")) {
+		t.Error("capture written in the clear")
+	}
+	if plain, err := openBytes(sealed); err != nil || !bytes.Equal(plain, program) {
+		t.Errorf("openBytes = %q, %v; want the program", plain, err)
+	}
+
+	if err := removeCanaryCaptures(12); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(dir)
+	var left []string
+	for _, e := range entries {
+		left = append(left, e.Name())
+	}
+	if want := []string{"job-123.tspl.enc"}; !slices.Equal(left, want) {
+		t.Errorf("left %v, want %v", left, want)
+	}
+}
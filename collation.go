@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+
+	"barcode-pos/tsplprinter"
+)
+
+const MaxSetSize = 10
+
+// Collation modes for jobs that print a set of labels per unit.
+const (
+	CollationGrouped     = "grouped"     // all copies of label 1, then all of label 2, ...
+	CollationInterleaved = "interleaved" // label 1, label 2, ... for each unit in turn
+)
+
+// LabelSpec is an additional label printed alongside the main one for every
+// unit of a set job (e.g. a care label and price tag next to the item label).
+// It shares the job's printer, size and direction.
+type LabelSpec struct {
+	TopText     string `json:"topText"`
+	BarcodeData string `json:"barcodeData"`
+	Symbology   string `json:"symbology"`
+}
+
+func applySetDefaults(req *PrintRequest) {
+	if req.Collation == "" {
+		req.Collation = CollationGrouped
+	}
+	req.Collation = strings.ToLower(req.Collation)
+	for i := range req.Set {
+		spec := &req.Set[i]
+		if spec.Symbology == "" {
+			spec.Symbology = tsplprinter.SymCode128
+		}
+		spec.Symbology = strings.ToUpper(spec.Symbology)
+		if len(spec.TopText) > MaxTopTextLength {
+			spec.TopText = spec.TopText[:MaxTopTextLength]
+		}
+	}
+}
+
+func validateSet(req *PrintRequest) error {
+	if req.Collation != CollationGrouped && req.Collation != CollationInterleaved {
+		return codedErrorf(ErrInvalidLabelSet, "collation must be %q or %q", CollationGrouped, CollationInterleaved)
+	}
+	if len(req.Set) > MaxSetSize {
+		return codedErrorf(ErrInvalidLabelSet, "set must not exceed %d labels", MaxSetSize)
+	}
+	for i, spec := range req.Set {
+		if spec.BarcodeData == "" {
+			return codedErrorf(ErrMissingBarcode, "set[%d].barcodeData is required", i)
+		}
+		if len(spec.BarcodeData) > MaxBarcodeDataLength {
+			return codedErrorf(ErrBarcodeTooLong, "set[%d].barcodeData must not exceed %d chars", i, MaxBarcodeDataLength)
+		}
+		if !slices.Contains(tsplprinter.Symbologies, spec.Symbology) {
+			return codedErrorf(ErrInvalidSymbology, "set[%d].symbology must be one of %s", i, strings.Join(tsplprinter.Symbologies, ", "))
+		}
+		if err := tsplprinter.ValidateData(spec.Symbology, spec.BarcodeData); err != nil {
+			return codedErrorf(ErrInvalidSymbology, "set[%d].barcodeData: %s", i, err)
+		}
+	}
+	return nil
+}
+
+// encodeSet serializes (and, with a key loaded, encrypts) a label set for storage.
+func encodeSet(set []LabelSpec) (string, error) {
+	if len(set) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(set)
+	if err != nil {
+		return "", err
+	}
+	return sealField(string(data))
+}
+
+func decodeSet(stored string) ([]LabelSpec, error) {
+	plain, err := openField(stored)
+	if err != nil || plain == "" {
+		return nil, err
+	}
+	var set []LabelSpec
+	if err := json.Unmarshal([]byte(plain), &set); err != nil {
+		return nil, errors.New("corrupt label set")
+	}
+	return set, nil
+}
+
+// jobLabels expands a job into the labels to send, in print order, adjusted to
+// the printer's capabilities. Grouped jobs print each member of the set
+// PrintCount times and cut after each group; interleaved jobs print the whole
+// set once per unit and cut after each unit so a unit's labels stay together.
+// It fails when a member can't be fitted to the printer.
+func jobLabels(req PrintRequest, caps tsplprinter.Capabilities) ([]tsplprinter.Label, []string, error) {
+	members := []tsplprinter.Label{{
+		TopText:     req.TopText,
+		BarcodeData: req.BarcodeData,
+		Symbology:   req.Symbology,
+	}}
+	for _, spec := range req.Set {
+		members = append(members, tsplprinter.Label{
+			TopText:     spec.TopText,
+			BarcodeData: spec.BarcodeData,
+			Symbology:   spec.Symbology,
+		})
+	}
+
+	var warnings []string
+	for i := range members {
+		m := &members[i]
+		m.SizeX, m.SizeY = req.SizeX, req.SizeY
+		m.Direction = req.Direction
+		m.Speed = req.Speed
+		m.Density = req.Density
+		adjusted, err := caps.Adjust(m)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, w := range adjusted {
+			if !slices.Contains(warnings, w) {
+				warnings = append(warnings, w)
+			}
+		}
+	}
+
+	if req.Collation != CollationInterleaved || len(members) == 1 {
+		for i := range members {
+			members[i].Copies = req.PrintCount
+		}
+		return members, warnings, nil
+	}
+
+	cut := caps.Cutter
+	labels := make([]tsplprinter.Label, 0, len(members)*req.PrintCount)
+	for unit := 0; unit < req.PrintCount; unit++ {
+		for i, m := range members {
+			m.Copies = 1
+			m.Cut = cut && i == len(members)-1
+			labels = append(labels, m)
+		}
+	}
+	return labels, warnings, nil
+}
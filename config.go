@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"barcode-pos/tsplprinter"
+)
+
+// ConfigPath is the optional service configuration file. Without it the
+// built-in defaults apply.
+const ConfigPath = "config.json"
+
+const MaxWorkerCount = 16
+
+type Config struct {
+	// Workers is the number of print workers; 0 means WorkerCount.
+	Workers  int             `json:"workers"`
+	Defaults PrinterDefaults `json:"defaults"`
+
+	Hooks  []HookConfig `json:"hooks"`
+	Quotas QuotaConfig  `json:"quotas"`
+	IPP    IPPConfig    `json:"ipp"`
+	Scale  ScaleConfig  `json:"scale"`
+	Canary CanaryConfig `json:"canary"`
+
+	// Alerts maps a printer feedback event to its beep pattern.
+	Alerts map[string]AlertConfig `json:"alerts"`
+
+	Tenants []TenantBinding `json:"tenants"`
+}
+
+// PrinterDefaults fill in what a print request leaves out.
+type PrinterDefaults struct {
+	VID       string `json:"vid"`
+	PID       string `json:"pid"`
+	SizeX     int    `json:"sizeX"` // mm
+	SizeY     int    `json:"sizeY"` // mm
+	Density   int    `json:"density"`
+	Symbology string `json:"symbology"`
+}
+
+var config atomic.Pointer[Config]
+
+func currentConfig() *Config {
+	return config.Load()
+}
+
+// loadConfig reads ConfigPath, validates it and makes it current. On error the
+// previous configuration stays in effect.
+func loadConfig() error {
+	cfg := &Config{}
+	data, err := os.ReadFile(ConfigPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("read %s: %w", ConfigPath, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return fmt.Errorf("parse %s: %w", ConfigPath, err)
+		}
+	}
+	cfg.fillBuiltins()
+	if err := cfg.validate(); err != nil {
+		return fmt.Errorf("%s: %w", ConfigPath, err)
+	}
+	config.Store(cfg)
+	return nil
+}
+
+// fillBuiltins sets the built-in value of every setting the file leaves out.
+func (cfg *Config) fillBuiltins() {
+	if cfg.Workers == 0 {
+		cfg.Workers = WorkerCount
+	}
+	d := &cfg.Defaults
+	if d.VID == "" {
+		d.VID = "0x0fe6"
+	}
+	if d.PID == "" {
+		d.PID = "0x8800"
+	}
+	if d.SizeX == 0 {
+		d.SizeX = 45
+	}
+	if d.SizeY == 0 {
+		d.SizeY = 35
+	}
+	if d.Symbology == "" {
+		d.Symbology = tsplprinter.SymCode128
+	}
+}
+
+func (cfg *Config) validate() error {
+	if cfg.Workers < 1 || cfg.Workers > MaxWorkerCount {
+		return fmt.Errorf("workers must be between 1 and %d", MaxWorkerCount)
+	}
+	if err := cfg.Defaults.validate(); err != nil {
+		return fmt.Errorf("defaults: %w", err)
+	}
+	if err := cfg.Canary.validate(); err != nil {
+		return fmt.Errorf("canary: %w", err)
+	}
+	if err := cfg.Scale.validate(); err != nil {
+		return fmt.Errorf("scale: %w", err)
+	}
+	for i := range cfg.Tenants {
+		if err := cfg.Tenants[i].validate(); err != nil {
+			return fmt.Errorf("tenants[%d]: %w", i, err)
+		}
+	}
+	for i := range cfg.Hooks {
+		if err := cfg.Hooks[i].validate(); err != nil {
+			return fmt.Errorf("hooks[%d]: %w", i, err)
+		}
+	}
+	for event, a := range cfg.Alerts {
+		if !slices.Contains(alertEvents, event) {
+			return fmt.Errorf("alerts: unknown event %q, want one of %s", event, strings.Join(alertEvents, ", "))
+		}
+		if err := a.validate(); err != nil {
+			return fmt.Errorf("alerts.%s: %w", event, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// KeyFilePath holds a hex-encoded 32-byte AES key. When the file exists, job
+// payload fields (topText, barcodeData) are stored AES-GCM encrypted in the
+// DB. Rows stored in the clear before the key was added are sealed at the
+// next start. Create one with: openssl rand -hex 32 > jobs.key
+const KeyFilePath = "jobs.key"
+
+// encPrefix marks an encrypted column value so plaintext rows written before
+// the key was added can be told apart.
+const encPrefix = "enc:v1:"
+
+var payloadAEAD cipher.AEAD
+
+func loadPayloadKey() error {
+	data, err := os.ReadFile(KeyFilePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read key file: %w", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("key file %s is not hex: %w", KeyFilePath, err)
+	}
+	if len(key) != 32 {
+		return fmt.Errorf("key file %s must hold 32 bytes, got %d", KeyFilePath, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	payloadAEAD, err = cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	barcodeSearchKey = deriveSearchKey(key)
+	return nil
+}
+
+// resealPlaintextJobs encrypts the payload fields of jobs stored before the
+// key file was added. Once done, later starts find nothing to do.
+func resealPlaintextJobs() error {
+	if payloadAEAD == nil {
+		return nil
+	}
+	plain := `(%[1]s != '' AND %[1]s NOT LIKE '` + encPrefix + `%%')`
+	rows, err := db.Query(`SELECT id, COALESCE(topText, ''), COALESCE(barcodeData, ''), labelSet, rawData FROM jobs WHERE ` +
+		fmt.Sprintf(plain, "topText") + ` OR ` + fmt.Sprintf(plain, "barcodeData") + ` OR ` +
+		fmt.Sprintf(plain, "labelSet") + ` OR ` + fmt.Sprintf(plain, "rawData"))
+	if err != nil {
+		return err
+	}
+	type payload struct {
+		id     int
+		fields [4]string
+	}
+	var jobs []payload
+	for rows.Next() {
+		var p payload
+		if err := rows.Scan(&p.id, &p.fields[0], &p.fields[1], &p.fields[2], &p.fields[3]); err != nil {
+			rows.Close()
+			return err
+		}
+		jobs = append(jobs, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range jobs {
+		for i, f := range p.fields {
+			if f == "" || strings.HasPrefix(f, encPrefix) {
+				continue
+			}
+			if p.fields[i], err = sealField(f); err != nil {
+				return err
+			}
+		}
+		if _, err := db.Exec(`UPDATE jobs SET topText = ?, barcodeData = ?, labelSet = ?, rawData = ? WHERE id = ?`,
+			p.fields[0], p.fields[1], p.fields[2], p.fields[3], p.id); err != nil {
+			return err
+		}
+	}
+	if len(jobs) > 0 {
+		log.Printf("Job payload encryption: sealed %d jobs stored in the clear", len(jobs))
+	}
+	return nil
+}
+
+// sealField encrypts a payload field for storage. It is a no-op when no key is loaded.
+func sealField(s string) (string, error) {
+	if payloadAEAD == nil {
+		return s, nil
+	}
+	sealed, err := sealBytes([]byte(s))
+	if err != nil {
+		return "", err
+	}
+	return encPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openField decrypts a stored payload field. Plaintext values are returned as is.
+func openField(s string) (string, error) {
+	if !strings.HasPrefix(s, encPrefix) {
+		return s, nil
+	}
+	if payloadAEAD == nil {
+		return "", errors.New("encrypted job payload but no key file loaded")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, encPrefix))
+	if err != nil {
+		return "", err
+	}
+	plain, err := openBytes(sealed)
+	if err != nil {
+		return "", fmt.Errorf("decrypt job payload: %w", err)
+	}
+	return string(plain), nil
+}
+
+// sealBytes encrypts b with the payload key as nonce followed by ciphertext.
+// The caller checks that a key is loaded.
+func sealBytes(b []byte) ([]byte, error) {
+	nonce := make([]byte, payloadAEAD.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return payloadAEAD.Seal(nonce, nonce, b, nil), nil
+}
+
+// openBytes decrypts what sealBytes returned.
+func openBytes(sealed []byte) ([]byte, error) {
+	ns := payloadAEAD.NonceSize()
+	if len(sealed) < ns {
+		return nil, errors.New("encrypted data too short")
+	}
+	return payloadAEAD.Open(nil, sealed[:ns], sealed[ns:], nil)
+}
+
+// openPayload decrypts the payload fields of a request read from the DB.
+func openPayload(req *PrintRequest) error {
+	var err error
+	if req.TopText, err = openField(req.TopText); err != nil {
+		return err
+	}
+	req.BarcodeData, err = openField(req.BarcodeData)
+	return err
+}
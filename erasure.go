@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/labstack/echo/v4"
+)
+
+type EraseRequest struct {
+	Subject string `json:"subject"`
+	// DryRun only counts the jobs that would be redacted.
+	DryRun bool `json:"dryRun"`
+}
+
+// eraseSubjectHandler redacts the payload of every finished job that prints
+// the subject: a label whose barcode equals it, or whose top text (or a text
+// field of a raw program) contains it as whole words. The job rows themselves
+// (printer, size, count, status, timestamps) are kept for reporting; their
+// canary captures are deleted.
+// Pending and in-progress jobs are left alone and reported as "active" so the
+// caller can retry once they finish. Jobs whose payload can't be decrypted
+// are skipped and listed as "unreadable". The subject is only read from the
+// JSON body, never the query string, so it stays out of the access log.
+func eraseSubjectHandler(c echo.Context) error {
+	var req EraseRequest
+	if err := (&echo.DefaultBinder{}).BindBody(c, &req); err != nil {
+		return apiError(c, http.StatusBadRequest, ErrInvalidJSON, "Invalid JSON")
+	}
+	req.Subject = strings.TrimSpace(req.Subject)
+	if req.Subject == "" {
+		return apiError(c, http.StatusBadRequest, ErrInvalidRequest, "subject is required")
+	}
+
+	// Scan without dbMu so workers keep dispatching meanwhile
+	rows, err := db.Query(`SELECT ` + jobColumns + ` FROM jobs WHERE redactedAt IS NULL`)
+	if err != nil {
+		return apiError(c, http.StatusInternalServerError, ErrInternal, "Error searching jobs")
+	}
+	var matched []int
+	unreadable := []int{}
+	active := 0
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if job == nil {
+			rows.Close()
+			return apiError(c, http.StatusInternalServerError, ErrInternal, "Error reading jobs")
+		}
+		if err != nil {
+			unreadable = append(unreadable, job.ID)
+			continue
+		}
+		if !matchesSubject(job.Request, req.Subject) {
+			continue
+		}
+		if job.Status == StatusPending || job.Status == StatusInProgress {
+			active++
+			continue
+		}
+		matched = append(matched, job.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return apiError(c, http.StatusInternalServerError, ErrInternal, "Error searching jobs")
+	}
+
+	resp := echo.Map{"matched": len(matched), "redacted": 0, "active": active, "unreadable": unreadable, "dryRun": req.DryRun}
+	if req.DryRun {
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	redacted := 0
+	for _, id := range matched {
+		res, err := db.Exec(
+			`UPDATE jobs SET topText = '', barcodeData = '', labelSet = '', rawData = '', redactedAt = CURRENT_TIMESTAMP
+			 WHERE id = ? AND redactedAt IS NULL`,
+			id,
+		)
+		if err != nil {
+			return apiError(c, http.StatusInternalServerError, ErrInternal, "Error redacting jobs")
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			redacted++
+		}
+		if _, err := db.Exec(`DELETE FROM job_barcodes WHERE jobId = ?`, id); err != nil {
+			return apiError(c, http.StatusInternalServerError, ErrInternal, "Error redacting jobs")
+		}
+		if err := removeCanaryCaptures(id); err != nil {
+			return apiError(c, http.StatusInternalServerError, ErrInternal, "Error deleting canary captures")
+		}
+	}
+	resp["redacted"] = redacted
+	return c.JSON(http.StatusOK, resp)
+}
+
+// rawTextFields pulls the printed text out of a raw program: TSPL quoted
+// arguments and ZPL ^FD field data. Commands themselves never match.
+var rawTextFields = regexp.MustCompile(`"([^"\r\n]*)"|\^FD(.*?)\^FS`)
+
+func matchesSubject(req PrintRequest, subject string) bool {
+	words := textWords(subject)
+	matchText := func(text string) bool {
+		return text == subject || (len(words) > 0 && containsWords(textWords(text), words))
+	}
+
+	if req.BarcodeData == subject || matchText(req.TopText) {
+		return true
+	}
+	for _, spec := range req.Set {
+		if spec.BarcodeData == subject || matchText(spec.TopText) {
+			return true
+		}
+	}
+	for _, m := range rawTextFields.FindAllSubmatch(req.RawData, -1) {
+		if matchText(string(m[1])) || matchText(string(m[2])) {
+			return true
+		}
+	}
+	return false
+}
+
+// textWords splits text into lower-cased runs of letters and digits.
+func textWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// containsWords reports whether words appear consecutively in text.
+func containsWords(text, words []string) bool {
+	for i := 0; i+len(words) <= len(text); i++ {
+		if slices.Equal(text[i:i+len(words)], words) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestMatchesSubject(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     PrintRequest
+		subject string
+		want    bool
+	}{
+		{"barcode equal", PrintRequest{BarcodeData: "LOY-0042"}, "LOY-0042", true},
+		{"barcode prefix", PrintRequest{BarcodeData: "LOY-00421"}, "LOY-0042", false},
+		{"top text word", PrintRequest{TopText: "Patient 4711 Jane Doe"}, "4711", true},
+		{"top text digit inside number", PrintRequest{TopText: "Patient 4711"}, "1", false},
+		{"top text name", PrintRequest{TopText: "Doe, Jane"}, "doe jane", true},
+		{"top text partial word", PrintRequest{TopText: "Janette"}, "Jane", false},
+		{"set member", PrintRequest{Set: []LabelSpec{{BarcodeData: "X1"}, {TopText: "Jane Doe"}}}, "Jane Doe", true},
+		{"raw TSPL text field", PrintRequest{RawData: []byte("TEXT 10,10,\"2\",0,1,1,\"Jane Doe\"\r\nPRINT 1,1\r\n")}, "Jane Doe", true},
+		{"raw TSPL command", PrintRequest{RawData: []byte("SIZE 45 mm, 35 mm\r\nPRINT 1,1\r\n")}, "PRINT", false},
+		{"raw ZPL field", PrintRequest{RawData: []byte("^XA^FO10,10^FDLOY-0042^FS^XZ")}, "LOY-0042", true},
+		{"raw ZPL command", PrintRequest{RawData: []byte("^XA^FO10,10^FDhello^FS^XZ")}, "XA", false},
+		{"punctuation only", PrintRequest{TopText: "a - b"}, "-", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesSubject(tt.req, tt.subject); got != tt.want {
+				t.Errorf("matchesSubject(%q) = %v, want %v", tt.subject, got, tt.want)
+			}
+		})
+	}
+}
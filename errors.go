@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Error codes returned as "code" next to the human "error" message in every
+// error response. Clients branch on these; the codes are stable, the
+// messages may change.
+const (
+	ErrInvalidJSON        = "ERR_INVALID_JSON"
+	ErrInvalidRequest     = "ERR_INVALID_REQUEST"
+	ErrMissingBarcode     = "ERR_MISSING_BARCODE"
+	ErrBarcodeTooLong     = "ERR_BARCODE_TOO_LONG"
+	ErrInvalidSymbology   = "ERR_INVALID_SYMBOLOGY"
+	ErrInvalidLabelSize   = "ERR_INVALID_LABEL_SIZE"
+	ErrInvalidSpeed       = "ERR_INVALID_SPEED"
+	ErrInvalidDensity     = "ERR_INVALID_DENSITY"
+	ErrInvalidPriority    = "ERR_INVALID_PRIORITY"
+	ErrInvalidLabelSet    = "ERR_INVALID_LABEL_SET"
+	ErrInvalidPrinterName = "ERR_INVALID_PRINTER_NAME"
+	ErrPrinterOffline     = "ERR_PRINTER_OFFLINE"
+	ErrQuotaExceeded      = "ERR_QUOTA_EXCEEDED"
+	ErrUnknownPLU         = "ERR_UNKNOWN_PLU"
+	ErrJobNotFound        = "ERR_JOB_NOT_FOUND"
+	ErrJobErased          = "ERR_JOB_ERASED"
+	ErrRawJobUnsupported  = "ERR_RAW_JOB_UNSUPPORTED"
+	ErrInvalidConfig      = "ERR_INVALID_CONFIG"
+	ErrNotFound           = "ERR_NOT_FOUND"
+	ErrMethodNotAllowed   = "ERR_METHOD_NOT_ALLOWED"
+	ErrPayloadTooLarge    = "ERR_PAYLOAD_TOO_LARGE"
+	ErrInternal           = "ERR_INTERNAL"
+)
+
+// codeStatus is the HTTP status for each code; codes not listed are client
+// errors (400).
+var codeStatus = map[string]int{
+	ErrPayloadTooLarge:  http.StatusRequestEntityTooLarge,
+	ErrQuotaExceeded:    http.StatusTooManyRequests,
+	ErrJobNotFound:      http.StatusNotFound,
+	ErrNotFound:         http.StatusNotFound,
+	ErrJobErased:        http.StatusGone,
+	ErrMethodNotAllowed: http.StatusMethodNotAllowed,
+	ErrInternal:         http.StatusInternalServerError,
+}
+
+func statusForCode(code string) int {
+	if status, ok := codeStatus[code]; ok {
+		return status
+	}
+	return http.StatusBadRequest
+}
+
+// codedError is an error that knows its API error code.
+type codedError struct {
+	code string
+	msg  string
+}
+
+func (e *codedError) Error() string { return e.msg }
+
+func codedErrorf(code, format string, args ...any) error {
+	return &codedError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// apiError writes the standard error response.
+func apiError(c echo.Context, status int, code, msg string) error {
+	return c.JSON(status, echo.Map{"error": msg, "code": code})
+}
+
+// apiErrorFrom writes err as an error response, using the code carried by
+// err if it has one and code otherwise. The status follows from the code.
+func apiErrorFrom(c echo.Context, code string, err error) error {
+	var ce *codedError
+	if errors.As(err, &ce) {
+		code = ce.code
+	}
+	return apiError(c, statusForCode(code), code, err.Error())
+}
+
+// httpErrorHandler gives errors raised by echo itself (unknown routes, body
+// limits, panics) the same shape as handler errors.
+func httpErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+	status, msg := http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError)
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		status = he.Code
+		msg = fmt.Sprint(he.Message)
+	}
+	code := ErrInternal
+	switch status {
+	case http.StatusNotFound:
+		code = ErrNotFound
+	case http.StatusMethodNotAllowed:
+		code = ErrMethodNotAllowed
+	case http.StatusRequestEntityTooLarge:
+		code = ErrPayloadTooLarge
+	default:
+		if status < http.StatusInternalServerError {
+			code = ErrInvalidRequest
+		}
+	}
+	if c.Request().Method == http.MethodHead {
+		c.NoContent(status)
+		return
+	}
+	apiError(c, status, code, msg)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"barcode-pos/tsplprinter"
+
+	"github.com/labstack/echo/v4"
+)
+
+// CutTime is roughly how long the cutter stalls the feed per cut.
+const CutTime = 500 * time.Millisecond
+
+// PrinterTarget is a printer an estimate is computed for. RemainingStockMM is
+// the operator's estimate of label stock left on its roll, if known;
+// otherwise the roll tracked for the printer (see maintenanceHandler) is used.
+type PrinterTarget struct {
+	VID              string `json:"vid"`
+	PID              string `json:"pid"`
+	RemainingStockMM int    `json:"remainingStockMm"`
+}
+
+// EstimateRequest describes either a new job (same fields as a print request)
+// or an existing one by JobID, plus the printers to estimate for. Without
+// targets the job's own printer is used.
+type EstimateRequest struct {
+	PrintRequest
+	JobID   int64           `json:"jobId"`
+	Targets []PrinterTarget `json:"targets"`
+}
+
+type TargetEstimate struct {
+	VID              string   `json:"vid"`
+	PID              string   `json:"pid"`
+	Model            string   `json:"model"`
+	Seconds          float64  `json:"seconds"`
+	RemainingStockMM *int64   `json:"remainingStockMm,omitempty"`
+	StockSufficient  *bool    `json:"stockSufficient,omitempty"`
+	Warnings         []string `json:"warnings,omitempty"`
+	Error            string   `json:"error,omitempty"` // the job can't print on this target
+}
+
+type Estimate struct {
+	Labels   int              `json:"labels"`
+	LengthMM int              `json:"lengthMm"`
+	Targets  []TargetEstimate `json:"targets"`
+}
+
+// estimateHandler answers POST /estimate so staff can check stock and time
+// before starting a big run. Nothing is enqueued.
+func estimateHandler(c echo.Context) error {
+	var req EstimateRequest
+	if err := c.Bind(&req); err != nil {
+		return apiError(c, http.StatusBadRequest, ErrInvalidJSON, "Invalid JSON")
+	}
+
+	job := req.PrintRequest
+	if req.JobID != 0 {
+		stored, err := loadJob(req.JobID)
+		if err != nil {
+			return apiError(c, http.StatusInternalServerError, ErrInternal, "Error fetching job")
+		}
+		if stored == nil {
+			return apiError(c, http.StatusNotFound, ErrJobNotFound, "Job not found")
+		}
+		if stored.Request.RawFormat != "" {
+			return apiError(c, http.StatusBadRequest, ErrRawJobUnsupported, "Raw print jobs cannot be estimated")
+		}
+		job = stored.Request
+	} else {
+		applyDefaults(&job, requestDefaults(c))
+		if err := validateRequest(&job); err != nil {
+			return apiErrorFrom(c, ErrInvalidRequest, err)
+		}
+	}
+
+	targets := req.Targets
+	if len(targets) == 0 {
+		targets = []PrinterTarget{{VID: job.VID, PID: job.PID}}
+	}
+	return c.JSON(http.StatusOK, estimateJob(job, targets))
+}
+
+func estimateJob(job PrintRequest, targets []PrinterTarget) Estimate {
+	var est Estimate
+	for _, t := range targets {
+		caps := tsplprinter.LookupCapabilities(t.VID, t.PID)
+		labels, warnings, err := jobLabels(job, caps)
+		if err != nil {
+			est.Targets = append(est.Targets, TargetEstimate{VID: t.VID, PID: t.PID, Model: caps.Model, Error: err.Error()})
+			continue
+		}
+
+		copies, lengthMM, cuts := feedTotals(labels)
+		speed := caps.MaxSpeedIPS
+		if len(labels) > 0 && labels[0].Speed > 0 {
+			speed = labels[0].Speed
+		}
+		seconds := float64(cuts) * CutTime.Seconds()
+		if speed > 0 {
+			seconds += float64(lengthMM) / (float64(speed) * 25.4)
+		}
+
+		te := TargetEstimate{
+			VID:      t.VID,
+			PID:      t.PID,
+			Model:    caps.Model,
+			Seconds:  float64(int(seconds*10+0.5)) / 10,
+			Warnings: warnings,
+		}
+		if t.RemainingStockMM > 0 {
+			remaining := int64(t.RemainingStockMM)
+			te.RemainingStockMM = &remaining
+		} else if st, err := loadPrinterStats(tsplprinter.PrinterName(t.VID, t.PID)); err == nil {
+			te.RemainingStockMM = st.RollRemainingMM
+		}
+		if te.RemainingStockMM != nil {
+			ok := *te.RemainingStockMM >= int64(lengthMM)
+			te.StockSufficient = &ok
+		}
+		est.Labels, est.LengthMM = copies, lengthMM
+		est.Targets = append(est.Targets, te)
+	}
+	return est
+}
+
+// feedTotals sums the copies, roll length and cuts a run of labels uses.
+func feedTotals(labels []tsplprinter.Label) (copies, lengthMM, cuts int) {
+	for _, l := range labels {
+		copies += l.Copies
+		lengthMM += l.Copies * (l.SizeY + tsplprinter.GapMM)
+		if l.Cut {
+			cuts++
+		}
+	}
+	return copies, lengthMM, cuts
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"barcode-pos/tsplprinter"
+)
+
+// Hook events.
+const (
+	HookPre  = "pre"  // before a job is sent to the printer
+	HookPost = "post" // after a job attempt finished, with its new status
+)
+
+// Hook failure policies.
+const (
+	HookIgnore = "ignore" // log and carry on
+	HookAbort  = "abort"  // pre hooks only: fail this attempt (it is retried like a print error)
+)
+
+const (
+	DefaultHookTimeout = 5 * time.Second
+	// HookWaitDelay is how long a timed-out command's children may keep its
+	// output open before the pipes are closed and the hook gives up on them.
+	HookWaitDelay = time.Second
+)
+
+// HookConfig is one configured pre/post print hook. Exactly one of Command
+// (argv, job details in BP_* environment variables) or URL (POSTed a
+// HookEvent as JSON) is set.
+type HookConfig struct {
+	Name       string   `json:"name"`
+	Event      string   `json:"event"`
+	Command    []string `json:"command"`
+	URL        string   `json:"url"`
+	TimeoutSec int      `json:"timeoutSec"`
+	OnFailure  string   `json:"onFailure"`
+}
+
+// HookEvent is what a hook is told about the job. Label content is left out
+// on purpose; hooks can look the job up by ID.
+type HookEvent struct {
+	Event     string `json:"event"`
+	JobID     int    `json:"jobId"`
+	ShortCode string `json:"shortCode"`
+	Printer   string `json:"printer"`
+	Status    string `json:"status"`
+	Warning   string `json:"warning,omitempty"` // e.g. adjustments, or that a canary job wasn't printed
+	Attempt   int    `json:"attempt"`
+}
+
+func (h *HookConfig) validate() error {
+	if h.Event != HookPre && h.Event != HookPost {
+		return fmt.Errorf("event must be %q or %q", HookPre, HookPost)
+	}
+	if (len(h.Command) == 0) == (h.URL == "") {
+		return errors.New("exactly one of command or url is required")
+	}
+	if h.OnFailure == "" {
+		h.OnFailure = HookIgnore
+	}
+	if h.OnFailure != HookIgnore && h.OnFailure != HookAbort {
+		return fmt.Errorf("onFailure must be %q or %q", HookIgnore, HookAbort)
+	}
+	if h.TimeoutSec < 0 {
+		return errors.New("timeoutSec must not be negative")
+	}
+	if h.Name == "" {
+		h.Name = h.URL
+		if len(h.Command) > 0 {
+			h.Name = h.Command[0]
+		}
+	}
+	return nil
+}
+
+// runHooks runs every hook configured for the event, in order. It returns an
+// error only when a hook with the abort policy fails.
+func runHooks(event string, job *Job, status string) error {
+	ev := HookEvent{
+		Event:     event,
+		JobID:     job.ID,
+		ShortCode: job.ShortCode,
+		Printer:   tsplprinter.PrinterName(job.Request.VID, job.Request.PID),
+		Status:    status,
+		Warning:   job.Warning,
+		Attempt:   job.Attempts,
+	}
+	for _, h := range currentConfig().Hooks {
+		if h.Event != event {
+			continue
+		}
+		err := runHook(h, ev)
+		if err == nil {
+			continue
+		}
+		log.Printf("Hook %s (%s) for job %d failed: %v", h.Name, event, job.ID, err)
+		if h.OnFailure == HookAbort && event == HookPre {
+			return fmt.Errorf("hook %s: %w", h.Name, err)
+		}
+	}
+	return nil
+}
+
+func runHook(h HookConfig, ev HookEvent) error {
+	timeout := DefaultHookTimeout
+	if h.TimeoutSec > 0 {
+		timeout = time.Duration(h.TimeoutSec) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if h.URL != "" {
+		body, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("%s returned %s", h.URL, resp.Status)
+		}
+		return nil
+	}
+
+	cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+	// The context kills only the command itself, not what it started
+	cmd.WaitDelay = HookWaitDelay
+	cmd.Env = append(os.Environ(),
+		"BP_EVENT="+ev.Event,
+		"BP_JOB_ID="+strconv.Itoa(ev.JobID),
+		"BP_SHORT_CODE="+ev.ShortCode,
+		"BP_PRINTER="+ev.Printer,
+		"BP_STATUS="+ev.Status,
+		"BP_WARNING="+ev.Warning,
+		"BP_ATTEMPT="+strconv.Itoa(ev.Attempt),
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if len(out) > 0 {
+			return fmt.Errorf("%w: %s", err, bytes.TrimSpace(out))
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRunHookTimeoutWithChildProcess(t *testing.T) {
+	// The shell forks sleep, which inherits the output pipe and outlives the
+	// killed shell
+	h := HookConfig{Name: "slow", Event: HookPre, Command: []string{"sh", "-c", "sleep 30; echo done"}, TimeoutSec: 1}
+	start := time.Now()
+	err := runHook(h, HookEvent{Event: HookPre, JobID: 1})
+	if err == nil {
+		t.Fatal("runHook succeeded, want a timeout error")
+	}
+	if d := time.Since(start); d > time.Second+HookWaitDelay+time.Second {
+		t.Errorf("runHook returned after %v, want about %v", d, time.Second+HookWaitDelay)
+	}
+}
@@ -0,0 +1,249 @@
+// Package ipp implements the small subset of the IPP/1.1 wire encoding
+// (RFC 8010) needed to accept raw print jobs from legacy software.
+package ipp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Operation IDs.
+const (
+	OpPrintJob             uint16 = 0x0002
+	OpValidateJob          uint16 = 0x0004
+	OpCancelJob            uint16 = 0x0008
+	OpGetJobAttributes     uint16 = 0x0009
+	OpGetJobs              uint16 = 0x000A
+	OpGetPrinterAttributes uint16 = 0x000B
+)
+
+// Status codes.
+const (
+	StatusOK                        uint16 = 0x0000
+	StatusBadRequest                uint16 = 0x0400
+	StatusNotPossible               uint16 = 0x0404
+	StatusNotFound                  uint16 = 0x0406
+	StatusRequestEntityTooLarge     uint16 = 0x0409
+	StatusDocumentFormatUnsupported uint16 = 0x040A
+	StatusInternalError             uint16 = 0x0500
+	StatusOperationNotSupported     uint16 = 0x0501
+)
+
+// Delimiter tags.
+const (
+	TagOperation   byte = 0x01
+	TagJob         byte = 0x02
+	TagEnd         byte = 0x03
+	TagPrinter     byte = 0x04
+	TagUnsupported byte = 0x05
+)
+
+// Value tags.
+const (
+	TagInteger         byte = 0x21
+	TagBoolean         byte = 0x22
+	TagEnum            byte = 0x23
+	TagText            byte = 0x41
+	TagName            byte = 0x42
+	TagKeyword         byte = 0x44
+	TagURI             byte = 0x45
+	TagCharset         byte = 0x47
+	TagNaturalLanguage byte = 0x48
+	TagMimeMediaType   byte = 0x49
+)
+
+// Job states.
+const (
+	JobPending    = 3
+	JobProcessing = 5
+	JobAborted    = 8
+	JobCompleted  = 9
+)
+
+// Printer states.
+const (
+	PrinterIdle       = 3
+	PrinterProcessing = 4
+)
+
+type Attribute struct {
+	Tag    byte
+	Name   string
+	Values [][]byte
+}
+
+// String returns the first value as a string.
+func (a Attribute) String() string {
+	if len(a.Values) == 0 {
+		return ""
+	}
+	return string(a.Values[0])
+}
+
+// Int returns the first value of an integer or enum attribute.
+func (a Attribute) Int() (int, bool) {
+	if len(a.Values) == 0 || len(a.Values[0]) != 4 {
+		return 0, false
+	}
+	return int(int32(binary.BigEndian.Uint32(a.Values[0]))), true
+}
+
+type Group struct {
+	Tag   byte
+	Attrs []Attribute
+}
+
+// Message is an IPP request or response. Code is the operation ID of a
+// request or the status code of a response.
+type Message struct {
+	Major, Minor byte
+	Code         uint16
+	RequestID    uint32
+	Groups       []Group
+}
+
+// Lookup returns the named attribute of the first group with the given tag.
+func (m *Message) Lookup(groupTag byte, name string) (Attribute, bool) {
+	for _, g := range m.Groups {
+		if g.Tag != groupTag {
+			continue
+		}
+		for _, a := range g.Attrs {
+			if a.Name == name {
+				return a, true
+			}
+		}
+	}
+	return Attribute{}, false
+}
+
+// Decode parses an IPP request body and returns the message and the document
+// data that follows the attributes, if any.
+func Decode(body []byte) (*Message, []byte, error) {
+	r := bytes.NewReader(body)
+	var hdr [8]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, nil, fmt.Errorf("ipp header: %w", err)
+	}
+	m := &Message{
+		Major:     hdr[0],
+		Minor:     hdr[1],
+		Code:      binary.BigEndian.Uint16(hdr[2:4]),
+		RequestID: binary.BigEndian.Uint32(hdr[4:8]),
+	}
+
+	var group *Group
+	for {
+		tag, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, errors.New("ipp: missing end-of-attributes tag")
+		}
+		if tag == TagEnd {
+			return m, body[len(body)-r.Len():], nil
+		}
+		if tag < 0x10 {
+			m.Groups = append(m.Groups, Group{Tag: tag})
+			group = &m.Groups[len(m.Groups)-1]
+			continue
+		}
+		if group == nil {
+			return nil, nil, errors.New("ipp: attribute outside of a group")
+		}
+		name, err := readField(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		value, err := readField(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(name) == 0 {
+			// Additional value of the previous attribute
+			if len(group.Attrs) == 0 {
+				return nil, nil, errors.New("ipp: additional value without attribute")
+			}
+			prev := &group.Attrs[len(group.Attrs)-1]
+			prev.Values = append(prev.Values, value)
+			continue
+		}
+		group.Attrs = append(group.Attrs, Attribute{Tag: tag, Name: string(name), Values: [][]byte{value}})
+	}
+}
+
+func readField(r io.Reader) ([]byte, error) {
+	var n [2]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return nil, fmt.Errorf("ipp attribute: %w", err)
+	}
+	b := make([]byte, binary.BigEndian.Uint16(n[:]))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, fmt.Errorf("ipp attribute: %w", err)
+	}
+	return b, nil
+}
+
+// Encode writes the message and its attribute groups to w.
+func (m *Message) Encode(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	var hdr [8]byte
+	hdr[0], hdr[1] = m.Major, m.Minor
+	binary.BigEndian.PutUint16(hdr[2:4], m.Code)
+	binary.BigEndian.PutUint32(hdr[4:8], m.RequestID)
+	bw.Write(hdr[:])
+	for _, g := range m.Groups {
+		bw.WriteByte(g.Tag)
+		for _, a := range g.Attrs {
+			for i, v := range a.Values {
+				name := a.Name
+				if i > 0 {
+					name = ""
+				}
+				bw.WriteByte(a.Tag)
+				writeField(bw, []byte(name))
+				writeField(bw, v)
+			}
+		}
+	}
+	bw.WriteByte(TagEnd)
+	return bw.Flush()
+}
+
+func writeField(w *bufio.Writer, b []byte) {
+	var n [2]byte
+	binary.BigEndian.PutUint16(n[:], uint16(len(b)))
+	w.Write(n[:])
+	w.Write(b)
+}
+
+// String builds a string-valued attribute (text, name, keyword, uri, ...).
+func String(tag byte, name string, values ...string) Attribute {
+	a := Attribute{Tag: tag, Name: name}
+	for _, v := range values {
+		a.Values = append(a.Values, []byte(v))
+	}
+	return a
+}
+
+// Integer builds an integer or enum attribute.
+func Integer(tag byte, name string, values ...int) Attribute {
+	a := Attribute{Tag: tag, Name: name}
+	for _, v := range values {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(int32(v)))
+		a.Values = append(a.Values, b)
+	}
+	return a
+}
+
+// Boolean builds a boolean attribute.
+func Boolean(name string, v bool) Attribute {
+	b := byte(0)
+	if v {
+		b = 1
+	}
+	return Attribute{Tag: TagBoolean, Name: name, Values: [][]byte{{b}}}
+}
@@ -0,0 +1,98 @@
+package ipp
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	msg := &Message{
+		Major: 1, Minor: 1, Code: OpPrintJob, RequestID: 42,
+		Groups: []Group{
+			{Tag: TagOperation, Attrs: []Attribute{
+				String(TagCharset, "attributes-charset", "utf-8"),
+				String(TagNaturalLanguage, "attributes-natural-language", "en"),
+				String(TagMimeMediaType, "document-format", "application/octet-stream"),
+			}},
+			{Tag: TagJob, Attrs: []Attribute{
+				Integer(TagInteger, "copies", 3),
+				Integer(TagInteger, "negative", -1),
+				String(TagKeyword, "multi", "a", "b", "c"),
+				Boolean("flag", true),
+			}},
+		},
+	}
+	doc := []byte("SIZE 45 mm, 35 mm\r\nPRINT 1,1\r\n")
+
+	var buf bytes.Buffer
+	if err := msg.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	buf.Write(doc)
+
+	got, gotDoc, err := Decode(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, msg) {
+		t.Errorf("decoded message differs\ngot:  %+v\nwant: %+v", got, msg)
+	}
+	if !bytes.Equal(gotDoc, doc) {
+		t.Errorf("document = %q, want %q", gotDoc, doc)
+	}
+
+	copies, ok := got.Lookup(TagJob, "copies")
+	if n, okInt := copies.Int(); !ok || !okInt || n != 3 {
+		t.Errorf("copies = %v (found %v), want 3", n, ok)
+	}
+	if a, _ := got.Lookup(TagJob, "negative"); a.Values == nil {
+		t.Error("negative integer lost")
+	} else if n, _ := a.Int(); n != -1 {
+		t.Errorf("negative = %d, want -1", n)
+	}
+	if _, ok := got.Lookup(TagPrinter, "copies"); ok {
+		t.Error("Lookup matched an attribute in the wrong group")
+	}
+}
+
+// A Get-Printer-Attributes request as sent by CUPS, byte for byte.
+func TestDecodeWireBytes(t *testing.T) {
+	body := []byte{
+		0x01, 0x01, 0x00, 0x0B, 0x00, 0x00, 0x00, 0x07,
+		TagOperation,
+		TagCharset, 0x00, 0x12, 'a', 't', 't', 'r', 'i', 'b', 'u', 't', 'e', 's', '-', 'c', 'h', 'a', 'r', 's', 'e', 't',
+		0x00, 0x05, 'u', 't', 'f', '-', '8',
+		TagEnd,
+	}
+	m, doc, err := Decode(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Code != OpGetPrinterAttributes || m.RequestID != 7 || len(doc) != 0 {
+		t.Errorf("got code 0x%04x, request %d, %d document bytes", m.Code, m.RequestID, len(doc))
+	}
+	if a, ok := m.Lookup(TagOperation, "attributes-charset"); !ok || a.String() != "utf-8" {
+		t.Errorf("attributes-charset = %q (found %v)", a.String(), ok)
+	}
+
+	var buf bytes.Buffer
+	m.Encode(&buf)
+	if !bytes.Equal(buf.Bytes(), body) {
+		t.Errorf("re-encoded bytes differ\ngot:  % x\nwant: % x", buf.Bytes(), body)
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	(&Message{Major: 1, Minor: 1, Code: OpPrintJob, RequestID: 1, Groups: []Group{
+		{Tag: TagOperation, Attrs: []Attribute{String(TagCharset, "attributes-charset", "utf-8")}},
+	}}).Encode(&buf)
+	full := buf.Bytes()
+	// Every prefix that stops before the end-of-attributes tag is malformed
+	for n := 0; n < len(full)-1; n++ {
+		if _, _, err := Decode(full[:n]); err == nil {
+			t.Errorf("Decode of %d/%d bytes: no error", n, len(full))
+		}
+	}
+}
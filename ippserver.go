@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"barcode-pos/ipp"
+	"barcode-pos/tsplprinter"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// MaxIPPBodySize bounds an IPP request including its document.
+const MaxIPPBodySize = 4 << 20
+
+// Raw job formats.
+const (
+	RawTSPL = "tspl"
+	RawZPL  = "zpl"
+)
+
+// IPPConfig enables a plain-HTTP IPP listener that legacy software can print
+// raw TSPL/ZPL to. Every job goes to the one USB printer given by VID/PID.
+type IPPConfig struct {
+	Listen      string `json:"listen"` // e.g. ":631"; empty disables the listener
+	VID         string `json:"vid"`
+	PID         string `json:"pid"`
+	PrinterName string `json:"printerName"`
+}
+
+var ippDocumentFormats = []string{"application/octet-stream", "application/vnd.zebra-zpl", "text/plain"}
+
+var ippOperations = []int{
+	int(ipp.OpPrintJob), int(ipp.OpValidateJob), int(ipp.OpGetJobAttributes), int(ipp.OpGetPrinterAttributes),
+}
+
+func startIPPServer(cfg IPPConfig) {
+	if cfg.VID == "" {
+		cfg.VID = currentConfig().Defaults.VID
+	}
+	if cfg.PID == "" {
+		cfg.PID = currentConfig().Defaults.PID
+	}
+	if cfg.PrinterName == "" {
+		cfg.PrinterName = "barcode-pos"
+	}
+
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+	e.Use(middleware.Recover())
+	e.Use(middleware.BodyLimit(fmt.Sprintf("%dB", MaxIPPBodySize)))
+	e.POST("/*", func(c echo.Context) error {
+		return ippHandler(c, cfg)
+	})
+
+	log.Printf("Starting IPP listener on %s", cfg.Listen)
+	go func() {
+		if err := e.Start(cfg.Listen); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("IPP listener failed: %v", err)
+		}
+	}()
+}
+
+func ippHandler(c echo.Context, cfg IPPConfig) error {
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return c.NoContent(http.StatusRequestEntityTooLarge)
+	}
+	req, doc, err := ipp.Decode(body)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	resp := &ipp.Message{Major: 1, Minor: 1, Code: ipp.StatusOK, RequestID: req.RequestID}
+	op := ipp.Group{Tag: ipp.TagOperation, Attrs: []ipp.Attribute{
+		ipp.String(ipp.TagCharset, "attributes-charset", "utf-8"),
+		ipp.String(ipp.TagNaturalLanguage, "attributes-natural-language", "en"),
+	}}
+
+	var group *ipp.Group
+	switch req.Code {
+	case ipp.OpPrintJob:
+		group, resp.Code, err = ippPrintJob(c, cfg, req, doc)
+	case ipp.OpValidateJob:
+		_, resp.Code, err = ippDocumentFormat(req)
+	case ipp.OpGetJobAttributes:
+		group, resp.Code, err = ippGetJob(c, req)
+	case ipp.OpGetPrinterAttributes:
+		group = ippPrinterAttributes(c, cfg)
+	default:
+		resp.Code, err = ipp.StatusOperationNotSupported, fmt.Errorf("operation 0x%04x not supported", req.Code)
+	}
+	if err != nil {
+		op.Attrs = append(op.Attrs, ipp.String(ipp.TagText, "status-message", err.Error()))
+	}
+	resp.Groups = append(resp.Groups, op)
+	if group != nil {
+		resp.Groups = append(resp.Groups, *group)
+	}
+
+	var buf bytes.Buffer
+	resp.Encode(&buf)
+	return c.Blob(http.StatusOK, "application/ipp", buf.Bytes())
+}
+
+func ippDocumentFormat(req *ipp.Message) (string, uint16, error) {
+	format := "application/octet-stream"
+	if a, ok := req.Lookup(ipp.TagOperation, "document-format"); ok {
+		format = a.String()
+	}
+	for _, f := range ippDocumentFormats {
+		if f == format {
+			return format, ipp.StatusOK, nil
+		}
+	}
+	return "", ipp.StatusDocumentFormatUnsupported, fmt.Errorf("document-format %s not supported", format)
+}
+
+// ippPrintJob turns a Print-Job request into a raw job in our queue.
+func ippPrintJob(c echo.Context, cfg IPPConfig, req *ipp.Message, doc []byte) (*ipp.Group, uint16, error) {
+	if _, status, err := ippDocumentFormat(req); err != nil {
+		return nil, status, err
+	}
+	if len(bytes.TrimSpace(doc)) == 0 {
+		return nil, ipp.StatusBadRequest, errors.New("empty document")
+	}
+
+	job := PrintRequest{
+		VID:        cfg.VID,
+		PID:        cfg.PID,
+		PrintCount: 1,
+		RawFormat:  detectRawFormat(doc),
+		RawData:    doc,
+	}
+	if a, ok := req.Lookup(ipp.TagJob, "copies"); ok {
+		if n, ok := a.Int(); ok && n >= 1 && n <= MaxPrintCount {
+			job.PrintCount = n
+		}
+	}
+	var user string
+	if a, ok := req.Lookup(ipp.TagOperation, "requesting-user-name"); ok {
+		user = a.String()
+	}
+
+	src := requestSource(c, user)
+	if err := tsplprinter.CheckPrinterDevice(job.VID, job.PID); err != nil {
+		return nil, ipp.StatusNotPossible, fmt.Errorf("printer device not found: %w", err)
+	}
+	id, _, err := insertJob(job, src)
+	if isQuotaError(err) {
+		return nil, ipp.StatusNotPossible, err
+	}
+	if err != nil {
+		return nil, ipp.StatusInternalError, errors.New("failed to enqueue job")
+	}
+	return ippJobGroup(c, int(id), StatusPending), ipp.StatusOK, nil
+}
+
+func ippGetJob(c echo.Context, req *ipp.Message) (*ipp.Group, uint16, error) {
+	a, ok := req.Lookup(ipp.TagOperation, "job-id")
+	id, okInt := a.Int()
+	if !ok || !okInt {
+		return nil, ipp.StatusBadRequest, errors.New("job-id is required")
+	}
+	job, err := loadJob(int64(id))
+	if err != nil && job == nil {
+		return nil, ipp.StatusInternalError, errors.New("error fetching job")
+	}
+	if job == nil {
+		return nil, ipp.StatusNotFound, errors.New("job not found")
+	}
+	return ippJobGroup(c, job.ID, job.Status), ipp.StatusOK, nil
+}
+
+func ippJobGroup(c echo.Context, id int, status string) *ipp.Group {
+	state, reason := ipp.JobPending, "none"
+	switch status {
+	case StatusInProgress:
+		state, reason = ipp.JobProcessing, "job-printing"
+	case StatusDone:
+		state, reason = ipp.JobCompleted, "job-completed-successfully"
+	case StatusFailed:
+		state, reason = ipp.JobAborted, "aborted-by-system"
+	}
+	return &ipp.Group{Tag: ipp.TagJob, Attrs: []ipp.Attribute{
+		ipp.String(ipp.TagURI, "job-uri", fmt.Sprintf("%s/jobs/%d", ippPrinterURI(c), id)),
+		ipp.Integer(ipp.TagInteger, "job-id", id),
+		ipp.Integer(ipp.TagEnum, "job-state", state),
+		ipp.String(ipp.TagKeyword, "job-state-reasons", reason),
+	}}
+}
+
+func ippPrinterAttributes(c echo.Context, cfg IPPConfig) *ipp.Group {
+	state := ipp.PrinterIdle
+	var queued int
+	db.QueryRow(`SELECT COUNT(*) FROM jobs WHERE status IN (?, ?)`, StatusPending, StatusInProgress).Scan(&queued)
+	if queued > 0 {
+		state = ipp.PrinterProcessing
+	}
+	caps := tsplprinter.LookupCapabilities(cfg.VID, cfg.PID)
+	return &ipp.Group{Tag: ipp.TagPrinter, Attrs: []ipp.Attribute{
+		ipp.String(ipp.TagURI, "printer-uri-supported", ippPrinterURI(c)),
+		ipp.String(ipp.TagKeyword, "uri-security-supported", "none"),
+		ipp.String(ipp.TagKeyword, "uri-authentication-supported", "none"),
+		ipp.String(ipp.TagName, "printer-name", cfg.PrinterName),
+		ipp.String(ipp.TagText, "printer-make-and-model", caps.Model),
+		ipp.Integer(ipp.TagEnum, "printer-state", state),
+		ipp.String(ipp.TagKeyword, "printer-state-reasons", "none"),
+		ipp.Boolean("printer-is-accepting-jobs", true),
+		ipp.Integer(ipp.TagInteger, "queued-job-count", queued),
+		ipp.String(ipp.TagKeyword, "ipp-versions-supported", "1.0", "1.1"),
+		ipp.Integer(ipp.TagEnum, "operations-supported", ippOperations...),
+		ipp.String(ipp.TagCharset, "charset-configured", "utf-8"),
+		ipp.String(ipp.TagCharset, "charset-supported", "utf-8"),
+		ipp.String(ipp.TagNaturalLanguage, "natural-language-configured", "en"),
+		ipp.String(ipp.TagNaturalLanguage, "generated-natural-language-supported", "en"),
+		ipp.String(ipp.TagMimeMediaType, "document-format-default", ippDocumentFormats[0]),
+		ipp.String(ipp.TagMimeMediaType, "document-format-supported", ippDocumentFormats...),
+		ipp.String(ipp.TagKeyword, "pdl-override-supported", "not-attempted"),
+		ipp.String(ipp.TagKeyword, "compression-supported", "none"),
+	}}
+}
+
+func ippPrinterURI(c echo.Context) string {
+	return "ipp://" + c.Request().Host + c.Request().URL.Path
+}
+
+// detectRawFormat tells ZPL (^XA ... ^XZ) from TSPL by its start-of-format command.
+func detectRawFormat(doc []byte) string {
+	if bytes.Contains(doc, []byte("^XA")) {
+		return RawZPL
+	}
+	return RawTSPL
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"barcode-pos/tsplprinter"
+
+	"github.com/labstack/echo/v4"
+)
+
+// runLoadTest implements "barcode-pos loadtest": it pushes jobs through the
+// enqueue handler, the DB queue and the workers to simulated printers, then
+// reports throughput and latency. It runs on a throwaway DB with built-in
+// config, so no hooks fire and no real printer is touched.
+func runLoadTest(args []string) int {
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	jobs := fs.Int("jobs", 1000, "number of jobs to enqueue")
+	printers := fs.String("printers", "sim:3", "printers to drive, sim:N for N simulated printers")
+	workers := fs.Int("workers", WorkerCount, "number of print workers")
+	copies := fs.Int("copies", 1, "labels per job")
+	labelTime := fs.Duration("label-time", 20*time.Millisecond, "simulated print time per label")
+	clients := fs.Int("clients", 8, "concurrent enqueue clients")
+	timeout := fs.Duration("timeout", 10*time.Minute, "give up waiting for jobs after this long")
+	verbose := fs.Bool("v", false, "keep the service log")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	n, ok := strings.CutPrefix(*printers, "sim:")
+	simCount, err := strconv.Atoi(n)
+	if !ok || err != nil || simCount < 1 {
+		fmt.Fprintf(os.Stderr, "loadtest: -printers must be sim:N with N >= 1, got %q\n", *printers)
+		return 2
+	}
+	if *jobs < 1 || *clients < 1 {
+		fmt.Fprintln(os.Stderr, "loadtest: -jobs and -clients must be at least 1")
+		return 2
+	}
+
+	dir, err := os.MkdirTemp("", "barcode-pos-loadtest")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "loadtest: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &Config{Workers: *workers}
+	cfg.fillBuiltins()
+	if err := cfg.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "loadtest: %v\n", err)
+		return 2
+	}
+	config.Store(cfg)
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+	if err := initDB(filepath.Join(dir, "jobs.db")); err != nil {
+		fmt.Fprintf(os.Stderr, "loadtest: DB init error: %v\n", err)
+		return 1
+	}
+	pids := tsplprinter.AddSimPrinters(simCount, *labelTime)
+	simVID := fmt.Sprintf("0x%04x", tsplprinter.SimVID)
+
+	// Track completions reported by the workers
+	var (
+		mu       sync.Mutex
+		started  = map[int]time.Time{}
+		finished = map[int]time.Time{}
+		failed   int
+	)
+	progress := make(chan struct{}, 1)
+	onJobFinished = func(id int, status string) {
+		mu.Lock()
+		finished[id] = time.Now()
+		if status == StatusFailed {
+			failed++
+		}
+		mu.Unlock()
+		select {
+		case progress <- struct{}{}:
+		default:
+		}
+	}
+
+	e := echo.New()
+	e.POST("/print-barcode-labels", enqueueHandler)
+
+	begin := time.Now()
+	setWorkerCount(cfg.Workers)
+
+	// Enqueue from concurrent clients
+	next := make(chan int)
+	var (
+		wg         sync.WaitGroup
+		enqueueDur []time.Duration
+		rejected   int
+		firstErr   string
+	)
+	for c := 0; c < *clients; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				body, _ := json.Marshal(PrintRequest{
+					VID: simVID, PID: pids[i%len(pids)],
+					TopText: "LOAD TEST", BarcodeData: fmt.Sprintf("LT%07d", i),
+					PrintCount: *copies,
+				})
+				req := httptest.NewRequest(http.MethodPost, "/print-barcode-labels", bytes.NewReader(body))
+				req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+				rec := httptest.NewRecorder()
+				t0 := time.Now()
+				e.ServeHTTP(rec, req)
+				took := time.Since(t0)
+
+				var resp struct {
+					JobID int    `json:"jobId"`
+					Error string `json:"error"`
+				}
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				mu.Lock()
+				if rec.Code == http.StatusAccepted {
+					started[resp.JobID] = t0
+					enqueueDur = append(enqueueDur, took)
+				} else {
+					rejected++
+					if firstErr == "" {
+						firstErr = fmt.Sprintf("%d %s", rec.Code, resp.Error)
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < *jobs; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	enqueued := time.Since(begin)
+
+	// Wait for the queue to drain
+	deadline := time.After(*timeout)
+	for waiting := true; waiting; {
+		mu.Lock()
+		waiting = len(finished) < len(started)
+		mu.Unlock()
+		if !waiting {
+			break
+		}
+		select {
+		case <-progress:
+		case <-time.After(100 * time.Millisecond):
+		case <-deadline:
+			waiting = false
+		}
+	}
+	elapsed := time.Since(begin)
+	setWorkerCount(0)
+
+	mu.Lock()
+	defer mu.Unlock()
+	var latency []time.Duration
+	for id, t0 := range started {
+		if t1, ok := finished[id]; ok {
+			latency = append(latency, t1.Sub(t0))
+		}
+	}
+	done := len(latency) - failed
+	labels := int64(0)
+	for _, pid := range pids {
+		p, _ := tsplprinter.LookupSimPrinter(simVID, pid)
+		labels += p.Labels.Load()
+	}
+
+	fmt.Printf("jobs        %d submitted, %d done, %d failed, %d rejected, %d unfinished\n",
+		*jobs, done, failed, rejected, len(started)-len(latency))
+	if firstErr != "" {
+		fmt.Printf("            first rejection: %s\n", firstErr)
+	}
+	fmt.Printf("printers    %d simulated at %v/label, %d workers, %d clients\n", simCount, *labelTime, cfg.Workers, *clients)
+	fmt.Printf("elapsed     %v (enqueue %v)\n", elapsed.Round(time.Millisecond), enqueued.Round(time.Millisecond))
+	fmt.Printf("throughput  %.1f jobs/s, %.1f labels/s\n", float64(len(latency))/elapsed.Seconds(), float64(labels)/elapsed.Seconds())
+	fmt.Printf("enqueue     %s\n", percentiles(enqueueDur))
+	fmt.Printf("latency     %s (enqueue to finished)\n", percentiles(latency))
+	for _, pid := range pids {
+		p, _ := tsplprinter.LookupSimPrinter(simVID, pid)
+		fmt.Printf("printer     %s  %d labels  %d bytes\n", tsplprinter.PrinterName(simVID, pid), p.Labels.Load(), p.Bytes.Load())
+	}
+	if failed > 0 || rejected > 0 || len(latency) < len(started) {
+		return 1
+	}
+	return 0
+}
+
+func percentiles(d []time.Duration) string {
+	if len(d) == 0 {
+		return "n/a"
+	}
+	slices.Sort(d)
+	at := func(p float64) time.Duration {
+		i := int(p*float64(len(d))+0.999999) - 1
+		return d[max(0, min(i, len(d)-1))].Round(10 * time.Microsecond)
+	}
+	return fmt.Sprintf("p50 %v  p95 %v  p99 %v  max %v", at(0.50), at(0.95), at(0.99), d[len(d)-1].Round(10*time.Microsecond))
+}
@@ -6,6 +6,9 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -20,11 +23,21 @@ const (
 	MaxPrintCount        = 1000
 	MaxBarcodeDataLength = 100
 	MaxTopTextLength     = 50
+	MaxLabelWidthMM      = 120 // widest desktop TSPL head is 4.25"
+	MaxLabelHeightMM     = 500
 	MaxJobAttempts       = 3
 	WorkerCount          = 3
 	DBPath               = "jobs.db"
 
 	StaleThreshold = 10 * time.Minute
+
+	// Job priorities run from MinPriority to MaxPriority, higher first. Every
+	// AgingInterval a pending job gains one level, up to MaxPriority, so old
+	// low-priority jobs eventually tie with urgent ones and win on age.
+	MinPriority     = 0
+	MaxPriority     = 9
+	DefaultPriority = 5
+	AgingInterval   = 2 * time.Minute
 )
 
 const (
@@ -42,32 +55,103 @@ type PrintRequest struct {
 	Direction   int    `json:"direction"`
 	TopText     string `json:"topText"`
 	BarcodeData string `json:"barcodeData"`
+	Symbology   string `json:"symbology"`
+	Speed       int    `json:"speed"`
+	Density     int    `json:"density"` // print darkness 1-15, 0 keeps the printer setting
 	PrintCount  int    `json:"printCount"`
+	Priority    *int   `json:"priority"`
+
+	// Set adds labels printed with the main one for every unit.
+	Set       []LabelSpec `json:"set,omitempty"`
+	Collation string      `json:"collation"`
+
+	// RefCode prints the job's reprint short code in a corner of each label.
+	RefCode bool `json:"refCode"`
+
+	// TerminalID identifies the POS terminal or kiosk; the X-Terminal-ID
+	// header is used when it is empty.
+	TerminalID string `json:"terminalId"`
+
+	// RawFormat and RawData carry a ready-made printer program (e.g. from an
+	// IPP client) that is passed through as is, PrintCount times.
+	RawFormat string `json:"-"`
+	RawData   []byte `json:"-"`
+}
+
+func (r PrintRequest) priority() int {
+	if r.Priority == nil {
+		return DefaultPriority
+	}
+	return *r.Priority
+}
+
+// LabelCount is the number of physical labels the request prints.
+func (r PrintRequest) LabelCount() int {
+	return r.PrintCount * (1 + len(r.Set))
 }
 
 type Job struct {
 	ID        int
 	Request   PrintRequest
+	ShortCode string
+	Source    JobSource
 	Status    string
+	Warning   string
 	Attempts  int
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	// LabelCount is the number of labels stored when the job was enqueued.
+	LabelCount int
+
+	// EffectivePriority is the requested priority plus aging.
+	EffectivePriority int
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
 }
 
 var (
 	db   *sql.DB
 	dbMu sync.Mutex
+
+	// onJobFinished, when set, is called after a job's final status is stored.
+	onJobFinished func(jobID int, status string)
 )
 
 func main() {
-	if err := initDB(); err != nil {
+	if len(os.Args) > 1 && os.Args[1] == "loadtest" {
+		os.Exit(runLoadTest(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "canary-open" {
+		os.Exit(runCanaryOpen(os.Args[2:]))
+	}
+
+	if err := loadConfig(); err != nil {
+		log.Fatalf("Config error: %v", err)
+	}
+
+	if err := loadPayloadKey(); err != nil {
+		log.Fatalf("Key load error: %v", err)
+	}
+	if payloadAEAD != nil {
+		log.Printf("Job payload encryption enabled (%s)", KeyFilePath)
+	}
+
+	if err := initDB(DBPath); err != nil {
 		log.Fatalf("DB init error: %v", err)
 	}
 
 	go requeueStaleJobs()
+	go agePendingJobs()
+
+	setWorkerCount(currentConfig().Workers)
+	go watchConfig()
 
-	for i := 0; i < WorkerCount; i++ {
-		go worker(i + 1)
+	if ippCfg := currentConfig().IPP; ippCfg.Listen != "" {
+		startIPPServer(ippCfg)
+	}
+	if listen := currentConfig().Scale.Listen; listen != "" {
+		startScaleListener(listen)
+	}
+	if addr := currentConfig().Scale.Connect; addr != "" {
+		startScaleDialer(addr)
 	}
 
 	e := echo.New()
@@ -75,6 +159,7 @@ func main() {
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
 	e.Use(middleware.CORS())
+	e.HTTPErrorHandler = httpErrorHandler
 
 	fmt.Println("🚀 Barcode Print Service started securely on https://localhost:5000")
 
@@ -86,6 +171,23 @@ func main() {
 
 	e.GET("/job-status/:id", jobStatusHandler)
 
+	e.GET("/jobs", listJobsHandler)
+	e.GET("/jobs/search", searchJobsHandler)
+
+	e.GET("/reprint/:shortCode", reprintHandler)
+
+	e.DELETE("/jobs/by-subject", eraseSubjectHandler)
+
+	e.POST("/estimate", estimateHandler)
+
+	e.POST("/preview/diff", previewDiffHandler)
+
+	e.GET("/printers/:name/info", printerInfoHandler)
+	e.PUT("/printers/:name/maintenance", maintenanceHandler)
+
+	e.POST("/config/reload", reloadConfigHandler)
+	e.POST("/printers/:name/alert", printerAlertHandler)
+
 	certPath := "./certs/cert.pem"
 	keyPath := "./certs/cert.key"
 	log.Printf("Starting HTTPS server on :5000")
@@ -94,9 +196,11 @@ func main() {
 	}
 }
 
-func initDB() error {
+func initDB(path string) error {
 	var err error
-	db, err = sql.Open("sqlite3", DBPath)
+	// secure_delete zeroes freed content, so redacted and re-sealed payloads
+	// don't linger in free pages of the file.
+	db, err = sql.Open("sqlite3", path+"?_secure_delete=on")
 	if err != nil {
 		return err
 	}
@@ -112,7 +216,77 @@ func initDB() error {
 		status TEXT, attempts INTEGER,
 		createdAt DATETIME, updatedAt DATETIME
 	);`
-	_, err = db.Exec(stmt)
+	if _, err = db.Exec(stmt); err != nil {
+		return err
+	}
+	for _, col := range jobMigrations {
+		if err := ensureColumn("jobs", col.name, col.decl); err != nil {
+			return fmt.Errorf("migrate jobs.%s: %w", col.name, err)
+		}
+	}
+	if _, err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_jobs_shortCode ON jobs(shortCode)`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_jobs_terminal ON jobs(terminalId, createdAt)`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_jobs_dispatch ON jobs(status, effectivePriority DESC, createdAt)`); err != nil {
+		return err
+	}
+	if err := initPrinterStats(); err != nil {
+		return err
+	}
+	if err := resealPlaintextJobs(); err != nil {
+		return fmt.Errorf("seal plaintext jobs: %w", err)
+	}
+	return initBarcodeIndex()
+}
+
+// jobMigrations lists columns added to the jobs table after its first release.
+var jobMigrations = []struct{ name, decl string }{
+	{"redactedAt", "DATETIME"},
+	{"symbology", "TEXT NOT NULL DEFAULT '128'"},
+	{"speed", "INTEGER NOT NULL DEFAULT 0"},
+	{"warning", "TEXT NOT NULL DEFAULT ''"},
+	{"labelSet", "TEXT NOT NULL DEFAULT ''"},
+	{"collation", "TEXT NOT NULL DEFAULT 'grouped'"},
+	{"refCode", "INTEGER NOT NULL DEFAULT 0"},
+	{"shortCode", "TEXT"},
+	{"labelCount", "INTEGER NOT NULL DEFAULT 0"},
+	{"apiKey", "TEXT NOT NULL DEFAULT ''"},
+	{"clientIP", "TEXT NOT NULL DEFAULT ''"},
+	{"userAgent", "TEXT NOT NULL DEFAULT ''"},
+	{"terminalId", "TEXT NOT NULL DEFAULT ''"},
+	{"rawFormat", "TEXT NOT NULL DEFAULT ''"},
+	{"rawData", "TEXT NOT NULL DEFAULT ''"},
+	{"priority", fmt.Sprintf("INTEGER NOT NULL DEFAULT %d", DefaultPriority)},
+	{"effectivePriority", fmt.Sprintf("INTEGER NOT NULL DEFAULT %d", DefaultPriority)},
+	{"density", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+func ensureColumn(table, name, decl string) error {
+	rows, err := db.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			colName, colType string
+			dflt             sql.NullString
+		)
+		if err := rows.Scan(&cid, &colName, &colType, &notNull, &dflt, &pk); err != nil {
+			return err
+		}
+		if colName == name {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, name, decl))
 	return err
 }
 
@@ -134,64 +308,150 @@ func requeueStaleJobs() {
 	}
 }
 
+// agePendingJobs raises the effective priority of every pending job by one
+// level per AgingInterval. The level is stored with the job, so aging
+// survives restarts.
+func agePendingJobs() {
+	for {
+		time.Sleep(AgingInterval)
+		dbMu.Lock()
+		_, err := db.Exec(
+			`UPDATE jobs SET effectivePriority = effectivePriority + 1
+			 WHERE status = ? AND effectivePriority < ?`,
+			StatusPending, MaxPriority,
+		)
+		dbMu.Unlock()
+		if err != nil {
+			log.Printf("Error aging pending jobs: %v", err)
+		}
+	}
+}
+
 func enqueueHandler(c echo.Context) error {
 	var req PrintRequest
 	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid JSON"})
+		return apiError(c, http.StatusBadRequest, ErrInvalidJSON, "Invalid JSON")
 	}
 
-	applyDefaults(&req)
+	applyDefaults(&req, requestDefaults(c))
 	if err := validateRequest(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		return apiErrorFrom(c, ErrInvalidRequest, err)
 	}
 
+	src := requestSource(c, req.TerminalID)
 	if err := tsplprinter.CheckPrinterDevice(req.VID, req.PID); err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{"error": fmt.Sprintf("Printer device not found, please check connected or not: %s", err)})
+		return apiError(c, http.StatusBadRequest, ErrPrinterOffline, fmt.Sprintf("Printer device not found, please check connected or not: %s", err))
+	}
+
+	id, shortCode, err := insertJob(req, src)
+	if isQuotaError(err) {
+		return apiErrorFrom(c, ErrQuotaExceeded, err)
+	}
+	if err != nil {
+		return apiError(c, http.StatusInternalServerError, ErrInternal, "Failed to enqueue job")
+	}
+	return c.JSON(http.StatusAccepted, echo.Map{"jobId": id, "shortCode": shortCode, "status": StatusPending})
+}
+
+// insertJob stores a validated request as a pending job and returns its ID
+// and reprint short code. The terminal's quota is checked under the same
+// lock as the insert, so concurrent requests can't both slip under it.
+func insertJob(req PrintRequest, src JobSource) (int64, string, error) {
+	topText, err := sealField(req.TopText)
+	if err != nil {
+		return 0, "", err
+	}
+	barcodeData, err := sealField(req.BarcodeData)
+	if err != nil {
+		return 0, "", err
+	}
+	labelSet, err := encodeSet(req.Set)
+	if err != nil {
+		return 0, "", err
+	}
+	rawData, err := sealField(string(req.RawData))
+	if err != nil {
+		return 0, "", err
 	}
 
 	now := time.Now()
 	dbMu.Lock()
-	res, err := db.Exec(
-		`INSERT INTO jobs (vid,pid,sizeX,sizeY,direction,topText,barcodeData,printCount,status,attempts,createdAt,updatedAt)
-		 VALUES (?,?,?,?,?,?,?,?,?,?,?,?)`,
-		req.VID, req.PID, req.SizeX, req.SizeY,
-		req.Direction, req.TopText, req.BarcodeData,
-		req.PrintCount, StatusPending, 0, now, now,
-	)
-	dbMu.Unlock()
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to enqueue job"})
+	defer dbMu.Unlock()
+	if err := checkQuota(src.TerminalID, req.LabelCount()); err != nil {
+		return 0, "", err
+	}
+	for try := 0; ; try++ {
+		shortCode, err := newShortCode()
+		if err != nil {
+			return 0, "", err
+		}
+		res, err := db.Exec(
+			`INSERT INTO jobs (vid,pid,sizeX,sizeY,direction,topText,barcodeData,symbology,speed,density,labelSet,collation,refCode,shortCode,
+			   rawFormat,rawData,priority,effectivePriority,printCount,labelCount,apiKey,clientIP,userAgent,terminalId,status,attempts,createdAt,updatedAt)
+			 VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`,
+			req.VID, req.PID, req.SizeX, req.SizeY,
+			req.Direction, topText, barcodeData,
+			req.Symbology, req.Speed, req.Density, labelSet, req.Collation,
+			req.RefCode, shortCode,
+			req.RawFormat, rawData,
+			req.priority(), req.priority(),
+			req.PrintCount, req.LabelCount(),
+			src.APIKey, src.ClientIP, src.UserAgent, src.TerminalID,
+			StatusPending, 0, now, now,
+		)
+		if isUniqueViolation(err) && try < 5 {
+			continue // short code collision, draw another
+		}
+		if err != nil {
+			return 0, "", err
+		}
+		id, _ := res.LastInsertId()
+		if err := indexBarcodes(id, req); err != nil {
+			log.Printf("Job %d: barcode index error: %v", id, err)
+		}
+		return id, shortCode, nil
 	}
-	id, _ := res.LastInsertId()
-	return c.JSON(http.StatusAccepted, echo.Map{"jobId": id, "status": StatusPending})
 }
 
 func jobStatusHandler(c echo.Context) error {
 	id := c.Param("id")
-	var status string
-	err := db.QueryRow(`SELECT status FROM jobs WHERE id = ?`, id).Scan(&status)
+	var status, warning string
+	err := db.QueryRow(`SELECT status, warning FROM jobs WHERE id = ?`, id).Scan(&status, &warning)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return c.JSON(http.StatusNotFound, echo.Map{"error": "Job not found"})
+			return apiError(c, http.StatusNotFound, ErrJobNotFound, "Job not found")
 		}
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Error fetching job status"})
+		return apiError(c, http.StatusInternalServerError, ErrInternal, "Error fetching job status")
 	}
-	return c.JSON(http.StatusOK, echo.Map{"status": status})
+	resp := echo.Map{"status": status}
+	if warning != "" {
+		resp["warning"] = warning
+	}
+	return c.JSON(http.StatusOK, resp)
 }
 
-func applyDefaults(req *PrintRequest) {
+// applyDefaults fills in what the request leaves out from d, usually
+// requestDefaults for the caller.
+func applyDefaults(req *PrintRequest, d PrinterDefaults) {
 	if req.VID == "" {
-		req.VID = "0x0fe6"
+		req.VID = d.VID
 	}
 	if req.PID == "" {
-		req.PID = "0x8800"
+		req.PID = d.PID
 	}
 	if req.SizeX == 0 {
-		req.SizeX = 45
+		req.SizeX = d.SizeX
 	}
 	if req.SizeY == 0 {
-		req.SizeY = 35
+		req.SizeY = d.SizeY
+	}
+	if req.Density == 0 {
+		req.Density = d.Density
 	}
+	if req.Symbology == "" {
+		req.Symbology = d.Symbology
+	}
+	req.Symbology = strings.ToUpper(req.Symbology)
 	if req.PrintCount < 1 {
 		req.PrintCount = 1
 	} else if req.PrintCount > MaxPrintCount {
@@ -200,56 +460,168 @@ func applyDefaults(req *PrintRequest) {
 	if len(req.TopText) > MaxTopTextLength {
 		req.TopText = req.TopText[:MaxTopTextLength]
 	}
+	applySetDefaults(req)
+}
+
+// validateLabelSize bounds the label size, which also bounds the memory a
+// preview raster takes.
+func validateLabelSize(sizeX, sizeY int) error {
+	if sizeX < 1 || sizeX > MaxLabelWidthMM {
+		return codedErrorf(ErrInvalidLabelSize, "sizeX must be between 1 and %d mm", MaxLabelWidthMM)
+	}
+	if sizeY < 1 || sizeY > MaxLabelHeightMM {
+		return codedErrorf(ErrInvalidLabelSize, "sizeY must be between 1 and %d mm", MaxLabelHeightMM)
+	}
+	return nil
 }
 
 func validateRequest(req *PrintRequest) error {
+	if err := validateLabelSize(req.SizeX, req.SizeY); err != nil {
+		return err
+	}
 	if req.BarcodeData == "" {
-		return errors.New("barcodeData is required")
+		return codedErrorf(ErrMissingBarcode, "barcodeData is required")
 	}
 	if len(req.BarcodeData) > MaxBarcodeDataLength {
-		return fmt.Errorf("barcodeData must not exceed %d chars", MaxBarcodeDataLength)
+		return codedErrorf(ErrBarcodeTooLong, "barcodeData must not exceed %d chars", MaxBarcodeDataLength)
+	}
+	if !slices.Contains(tsplprinter.Symbologies, req.Symbology) {
+		return codedErrorf(ErrInvalidSymbology, "symbology must be one of %s", strings.Join(tsplprinter.Symbologies, ", "))
+	}
+	if err := tsplprinter.ValidateData(req.Symbology, req.BarcodeData); err != nil {
+		return codedErrorf(ErrInvalidSymbology, "barcodeData: %s", err)
+	}
+	if req.Speed < 0 {
+		return codedErrorf(ErrInvalidSpeed, "speed must not be negative")
+	}
+	if req.Density < 0 || req.Density > tsplprinter.MaxDensity {
+		return codedErrorf(ErrInvalidDensity, "density must be between 0 and %d", tsplprinter.MaxDensity)
+	}
+	if p := req.priority(); p < MinPriority || p > MaxPriority {
+		return codedErrorf(ErrInvalidPriority, "priority must be between %d and %d", MinPriority, MaxPriority)
+	}
+	return validateSet(req)
+}
+
+var (
+	workersMu   sync.Mutex
+	workerStops []chan struct{}
+)
+
+// setWorkerCount starts or stops workers until n are running. A stopped
+// worker finishes the job it is printing first.
+func setWorkerCount(n int) {
+	workersMu.Lock()
+	defer workersMu.Unlock()
+	for len(workerStops) < n {
+		stop := make(chan struct{})
+		workerStops = append(workerStops, stop)
+		go worker(len(workerStops), stop)
+	}
+	for len(workerStops) > n {
+		last := len(workerStops) - 1
+		close(workerStops[last])
+		workerStops = workerStops[:last]
 	}
-	return nil
 }
 
-func worker(id int) {
+func worker(id int, stop <-chan struct{}) {
 	for {
+		select {
+		case <-stop:
+			log.Printf("Worker %d stopped", id)
+			return
+		default:
+		}
 		job, err := fetchJob()
 		if err != nil {
 			log.Printf("Worker %d: fetch error: %v", id, err)
-			time.Sleep(time.Second)
-			continue
 		}
 		if job == nil {
-			time.Sleep(time.Second)
+			select {
+			case <-stop:
+			case <-time.After(time.Second):
+			}
 			continue
 		}
 		processJob(id, job)
 	}
 }
 
-func fetchJob() (*Job, error) {
-	dbMu.Lock()
-	defer dbMu.Unlock()
-	row := db.QueryRow(`
-		SELECT id, vid, pid, sizeX, sizeY, direction, topText, barcodeData, printCount, attempts
-		FROM jobs WHERE status = ? AND attempts < ? ORDER BY createdAt LIMIT 1`,
-		StatusPending, MaxJobAttempts,
-	)
+// jobColumns are the columns scanJob reads, in order.
+const jobColumns = `id, vid, pid, sizeX, sizeY, direction, topText, barcodeData, symbology, speed, density, labelSet, collation, refCode, shortCode, rawFormat, rawData, priority, effectivePriority, printCount, labelCount, apiKey, clientIP, userAgent, terminalId, status, warning, attempts, createdAt, updatedAt`
 
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanJob reads a row selected with jobColumns and decrypts its payload.
+// Payload errors are returned alongside the job so callers can still see its ID.
+func scanJob(row rowScanner) (*Job, error) {
 	var job Job
-	var attempts int
+	var labelSet, rawData string
+	var shortCode sql.NullString
 	err := row.Scan(
 		&job.ID,
 		&job.Request.VID, &job.Request.PID,
 		&job.Request.SizeX, &job.Request.SizeY, &job.Request.Direction,
 		&job.Request.TopText, &job.Request.BarcodeData,
-		&job.Request.PrintCount, &attempts,
+		&job.Request.Symbology, &job.Request.Speed, &job.Request.Density,
+		&labelSet, &job.Request.Collation,
+		&job.Request.RefCode, &shortCode,
+		&job.Request.RawFormat, &rawData,
+		&job.Request.Priority, &job.EffectivePriority,
+		&job.Request.PrintCount, &job.LabelCount,
+		&job.Source.APIKey, &job.Source.ClientIP, &job.Source.UserAgent, &job.Source.TerminalID,
+		&job.Status, &job.Warning, &job.Attempts,
+		&job.CreatedAt, &job.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	job.ShortCode = shortCode.String
+	job.Request.TerminalID = job.Source.TerminalID
+	err = openPayload(&job.Request)
+	if err == nil {
+		job.Request.Set, err = decodeSet(labelSet)
+	}
+	if err == nil {
+		rawData, err = openField(rawData)
+		job.Request.RawData = []byte(rawData)
+	}
+	if err != nil {
+		return &job, fmt.Errorf("job %d: %w", job.ID, err)
+	}
+	return &job, nil
+}
+
+// loadJob returns a job by ID, or nil if it does not exist.
+func loadJob(id int64) (*Job, error) {
+	job, err := scanJob(db.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return job, err
+}
+
+func fetchJob() (*Job, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	row := db.QueryRow(`
+		SELECT `+jobColumns+`
+		FROM jobs WHERE status = ? AND attempts < ? ORDER BY effectivePriority DESC, createdAt LIMIT 1`,
+		StatusPending, MaxJobAttempts,
 	)
+
+	job, err := scanJob(row)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
+		if job != nil {
+			// An unreadable payload will never print; fail it instead of retrying.
+			db.Exec(`UPDATE jobs SET status = ?, updatedAt = CURRENT_TIMESTAMP WHERE id = ?`, StatusFailed, job.ID)
+		}
 		return nil, err
 	}
 
@@ -262,37 +634,102 @@ func fetchJob() (*Job, error) {
 	}
 
 	job.Status = StatusInProgress
-	job.Attempts = attempts + 1
-	return &job, nil
+	job.Attempts++
+	return job, nil
 }
 
 func processJob(workerID int, job *Job) {
 	log.Printf("Worker %d processing job %d (attempt %d)", workerID, job.ID, job.Attempts)
-	err := tsplprinter.PrintBarcodeLabelTspl(
-		job.Request.VID, job.Request.PID,
-		job.Request.SizeX, job.Request.SizeY,
-		job.Request.Direction, job.Request.TopText,
-		job.Request.BarcodeData, job.Request.PrintCount,
-	)
+	var labels []tsplprinter.Label
+	var err error
+	permanent := false // retrying can't help
+	if job.Request.RawFormat == "" {
+		caps := tsplprinter.LookupCapabilities(job.Request.VID, job.Request.PID)
+		var warnings []string
+		labels, warnings, err = jobLabels(job.Request, caps)
+		if err != nil {
+			job.Warning = err.Error()
+			permanent = true
+		}
+		if job.Request.RefCode {
+			fits := true
+			for i := range labels {
+				labels[i].RefCode = job.ShortCode
+				fits = fits && tsplprinter.RefCodeFits(labels[i])
+			}
+			if !fits {
+				warnings = append(warnings, "no room for the ref code clear of the barcode, left off")
+			}
+		}
+		if len(labels) > 0 && labels[0].SizeX != job.Request.SizeX {
+			// Beep before the narrowed labels come out, not after
+			signalEvent(EventLabelNarrowed, job.Request.VID, job.Request.PID)
+		}
+		if len(warnings) > 0 {
+			job.Warning = strings.Join(warnings, "; ")
+			log.Printf("Worker %d job %d adjusted: %s", workerID, job.ID, job.Warning)
+		}
+	}
+	canary := canaryMode(job)
+	if err == nil {
+		err = runHooks(HookPre, job, StatusInProgress)
+	}
+	if err == nil {
+		switch {
+		case canary == CanaryDivert:
+			log.Printf("Worker %d job %d diverted to the canary backend", workerID, job.ID)
+			err = runCanary(job, labels)
+		case job.Request.RawFormat != "":
+			err = tsplprinter.PrintRaw(job.Request.VID, job.Request.PID, job.Request.RawData, job.Request.PrintCount)
+		default:
+			err = tsplprinter.PrintLabels(job.Request.VID, job.Request.PID, labels)
+		}
+	}
+	if err == nil && canary == CanaryMirror {
+		if cerr := runCanary(job, labels); cerr != nil {
+			log.Printf("Worker %d job %d canary capture error: %v", workerID, job.ID, cerr)
+		}
+	}
 
 	var newStatus string
 	if err != nil {
 		log.Printf("Worker %d job %d failed: %v", workerID, job.ID, err)
-		if job.Attempts >= MaxJobAttempts {
+		if permanent || job.Attempts >= MaxJobAttempts {
 			newStatus = StatusFailed
+			signalEvent(EventJobFailed, job.Request.VID, job.Request.PID)
 		} else {
 			newStatus = StatusPending
+			signalEvent(EventJobHeld, job.Request.VID, job.Request.PID)
+		}
+	} else if canary == CanaryDivert {
+		log.Printf("Worker %d job %d done (canary)", workerID, job.ID)
+		newStatus = StatusDone // nothing was fed, so no printer stats
+		// Tell whoever waits at the printer why nothing came out
+		if job.Warning != "" {
+			job.Warning += "; "
 		}
+		job.Warning += CanaryDivertedWarning
 	} else {
 		log.Printf("Worker %d job %d done", workerID, job.ID)
 		newStatus = StatusDone
+		copies, lengthMM, _ := feedTotals(labels)
+		if job.Request.RawFormat != "" {
+			copies = job.Request.LabelCount() // feed length of raw programs is unknown
+		}
+		if recordPrint(tsplprinter.PrinterName(job.Request.VID, job.Request.PID), copies, lengthMM) {
+			signalEvent(EventMaintenanceDue, job.Request.VID, job.Request.PID)
+		}
 	}
 
 	_, uerr := db.Exec(
-		`UPDATE jobs SET status = ?, updatedAt = CURRENT_TIMESTAMP WHERE id = ?`,
-		newStatus, job.ID,
+		`UPDATE jobs SET status = ?, warning = ?, updatedAt = CURRENT_TIMESTAMP WHERE id = ?`,
+		newStatus, job.Warning, job.ID,
 	)
 	if uerr != nil {
 		log.Printf("Worker %d update job %d error: %v", workerID, job.ID, uerr)
 	}
+	runHooks(HookPost, job, newStatus)
+	if onJobFinished != nil && newStatus != StatusPending {
+		onJobFinished(job.ID, newStatus)
+	}
 }
@@ -0,0 +1,171 @@
+// Package preview rasterizes label layouts for on-screen checks. The image is
+// an approximation of the printed label: text uses a built-in 5x7 font,
+// CODE128 is drawn bar-accurate and other symbologies as hatched boxes of
+// about the right size. Print direction is ignored.
+package preview
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"unicode"
+
+	"barcode-pos/tsplprinter"
+)
+
+var (
+	diffSame  = color.RGBA{0x60, 0x60, 0x60, 0xff}
+	diffOnlyA = color.RGBA{0xd0, 0x20, 0x20, 0xff} // removed
+	diffOnlyB = color.RGBA{0x20, 0xa0, 0x20, 0xff} // added
+	diffBlank = color.RGBA{0xff, 0xff, 0xff, 0xff}
+)
+
+// Render draws a layout at one pixel per printer dot, black on white.
+func Render(lay tsplprinter.Layout) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, lay.WidthDots, lay.HeightDots))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	for _, el := range lay.Elements {
+		switch {
+		case el.Kind != tsplprinter.ElemBarcode:
+			drawText(img, el)
+		case el.Symbology == tsplprinter.SymCode128:
+			drawCode128(img, el)
+		default:
+			drawHatched(img, el)
+		}
+	}
+	return img
+}
+
+func fill(img *image.Gray, r image.Rectangle) {
+	r = r.Intersect(img.Rect)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.Pix[img.PixOffset(x, y)] = 0
+		}
+	}
+}
+
+func drawText(img *image.Gray, el tsplprinter.Element) {
+	cellW, cellH := 8, 12
+	if el.Font == "2" {
+		cellW, cellH = 12, 20
+	}
+	scale := max(1, cellH/10)
+	for i, r := range el.Content {
+		g, ok := glyphs[unicode.ToUpper(r)]
+		if !ok {
+			g = unknownGlyph
+		}
+		x0 := el.X + i*cellW
+		for row, bits := range g {
+			for col := 0; col < 5; col++ {
+				if bits&(0x10>>col) == 0 {
+					continue
+				}
+				x, y := x0+col*scale, el.Y+row*scale
+				fill(img, image.Rect(x, y, x+scale, y+scale))
+			}
+		}
+	}
+}
+
+// drawCode128 draws the symbol with a 2-dot narrow module, as printed.
+func drawCode128(img *image.Gray, el tsplprinter.Element) {
+	const narrow = 2
+	x := el.X
+	for i, w := range code128Modules(el.Content) {
+		if i%2 == 0 {
+			fill(img, image.Rect(x, el.Y, x+w*narrow, el.Y+el.H))
+		}
+		x += w * narrow
+	}
+}
+
+func drawHatched(img *image.Gray, el tsplprinter.Element) {
+	r := image.Rect(el.X, el.Y, el.X+el.W, el.Y+el.H)
+	fill(img, image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+2))
+	fill(img, image.Rect(r.Min.X, r.Max.Y-2, r.Max.X, r.Max.Y))
+	fill(img, image.Rect(r.Min.X, r.Min.Y, r.Min.X+2, r.Max.Y))
+	fill(img, image.Rect(r.Max.X-2, r.Min.Y, r.Max.X, r.Max.Y))
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if (x+y)%8 < 2 {
+				fill(img, image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+}
+
+// Diff overlays two renders: ink in both is grey, ink only in a is red,
+// ink only in b is green. It returns the overlay and the number of pixels
+// that differ.
+func Diff(a, b *image.Gray) (*image.RGBA, int) {
+	bounds := a.Rect.Union(b.Rect)
+	out := image.NewRGBA(bounds)
+	changed := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			inkA := image.Pt(x, y).In(a.Rect) && a.GrayAt(x, y).Y < 0x80
+			inkB := image.Pt(x, y).In(b.Rect) && b.GrayAt(x, y).Y < 0x80
+			c := diffBlank
+			switch {
+			case inkA && inkB:
+				c = diffSame
+			case inkA:
+				c, changed = diffOnlyA, changed+1
+			case inkB:
+				c, changed = diffOnlyB, changed+1
+			}
+			out.SetRGBA(x, y, c)
+		}
+	}
+	return out, changed
+}
+
+// Change is one difference between two layouts.
+type Change struct {
+	Element string `json:"element"`
+	Field   string `json:"field"`
+	A       string `json:"a"`
+	B       string `json:"b"`
+}
+
+// Compare lists the differences between two layouts element by element.
+func Compare(a, b tsplprinter.Layout) []Change {
+	var changes []Change
+	add := func(element, field string, va, vb any) {
+		sa, sb := fmt.Sprint(va), fmt.Sprint(vb)
+		if sa != sb {
+			changes = append(changes, Change{Element: element, Field: field, A: sa, B: sb})
+		}
+	}
+	add("label", "size", fmt.Sprintf("%dx%d", a.WidthDots, a.HeightDots), fmt.Sprintf("%dx%d", b.WidthDots, b.HeightDots))
+
+	byKind := func(lay tsplprinter.Layout) map[string]tsplprinter.Element {
+		m := make(map[string]tsplprinter.Element)
+		for _, el := range lay.Elements {
+			m[el.Kind] = el
+		}
+		return m
+	}
+	ea, eb := byKind(a), byKind(b)
+	for _, kind := range []string{tsplprinter.ElemText, tsplprinter.ElemBarcode, tsplprinter.ElemRefCode} {
+		elA, okA := ea[kind]
+		elB, okB := eb[kind]
+		if okA != okB {
+			add(kind, "present", okA, okB)
+			continue
+		}
+		if !okA {
+			continue
+		}
+		add(kind, "content", elA.Content, elB.Content)
+		add(kind, "symbology", strings.ToUpper(elA.Symbology), strings.ToUpper(elB.Symbology))
+		add(kind, "position", fmt.Sprintf("%d,%d", elA.X, elA.Y), fmt.Sprintf("%d,%d", elB.X, elB.Y))
+	}
+	return changes
+}
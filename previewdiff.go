@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image/png"
+	"net/http"
+
+	"barcode-pos/preview"
+	"barcode-pos/tsplprinter"
+
+	"github.com/labstack/echo/v4"
+)
+
+// PreviewSpec is one side of a preview diff: either a label spec (same fields
+// as a print request) or an existing job by JobID. Label picks the member of
+// a set, 0 being the main label.
+type PreviewSpec struct {
+	PrintRequest
+	JobID int64 `json:"jobId"`
+	Label int   `json:"label"`
+}
+
+type PreviewDiffRequest struct {
+	A PreviewSpec `json:"a"`
+	B PreviewSpec `json:"b"`
+}
+
+// previewDiffHandler renders two label specs and returns the changed elements
+// plus an overlay image (red: only in a, green: only in b). With ?format=png
+// the overlay is returned as the response body instead.
+func previewDiffHandler(c echo.Context) error {
+	var req PreviewDiffRequest
+	if err := c.Bind(&req); err != nil {
+		return apiError(c, http.StatusBadRequest, ErrInvalidJSON, "Invalid JSON")
+	}
+	layA, err := previewLayout(req.A, requestDefaults(c))
+	if err != nil {
+		return apiErrorFrom(c, ErrInvalidRequest, fmt.Errorf("a: %w", err))
+	}
+	layB, err := previewLayout(req.B, requestDefaults(c))
+	if err != nil {
+		return apiErrorFrom(c, ErrInvalidRequest, fmt.Errorf("b: %w", err))
+	}
+
+	overlay, changedPixels := preview.Diff(preview.Render(layA), preview.Render(layB))
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, overlay); err != nil {
+		return apiError(c, http.StatusInternalServerError, ErrInternal, "Error encoding preview")
+	}
+	if c.QueryParam("format") == "png" {
+		return c.Blob(http.StatusOK, "image/png", buf.Bytes())
+	}
+
+	changes := preview.Compare(layA, layB)
+	if changes == nil {
+		changes = []preview.Change{}
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"changes":       changes,
+		"changedPixels": changedPixels,
+		"image":         "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+	})
+}
+
+func previewLayout(spec PreviewSpec, d PrinterDefaults) (tsplprinter.Layout, error) {
+	req := spec.PrintRequest
+	if spec.JobID != 0 {
+		job, err := loadJob(spec.JobID)
+		if err != nil {
+			return tsplprinter.Layout{}, codedErrorf(ErrInternal, "error fetching job")
+		}
+		if job == nil {
+			return tsplprinter.Layout{}, codedErrorf(ErrJobNotFound, "job not found")
+		}
+		if job.Request.RawFormat != "" {
+			return tsplprinter.Layout{}, codedErrorf(ErrRawJobUnsupported, "raw print jobs cannot be previewed")
+		}
+		req = job.Request
+		// Jobs stored before sizes were bounded may be too big to rasterize
+		if err := validateLabelSize(req.SizeX, req.SizeY); err != nil {
+			return tsplprinter.Layout{}, err
+		}
+	} else {
+		applyDefaults(&req, d)
+		if err := validateRequest(&req); err != nil {
+			return tsplprinter.Layout{}, err
+		}
+	}
+	if spec.Label < 0 || spec.Label > len(req.Set) {
+		return tsplprinter.Layout{}, codedErrorf(ErrInvalidRequest, "label must be between 0 and %d", len(req.Set))
+	}
+
+	// The first 1+len(Set) labels are the set's members in either collation.
+	labels, _, err := jobLabels(req, tsplprinter.LookupCapabilities(req.VID, req.PID))
+	if err != nil {
+		return tsplprinter.Layout{}, codedErrorf(ErrInvalidSymbology, "%s", err)
+	}
+	return tsplprinter.LabelLayout(labels[spec.Label]), nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"barcode-pos/tsplprinter"
+
+	"github.com/labstack/echo/v4"
+)
+
+// PrinterStats are the cumulative counters kept per printer. Length is the
+// roll length fed past the head, which is what wears it.
+type PrinterStats struct {
+	Name                   string     `json:"name"`
+	LabelsPrinted          int64      `json:"labelsPrinted"`
+	LengthPrintedMM        int64      `json:"lengthPrintedMm"`
+	LengthSinceServiceMM   int64      `json:"lengthSinceServiceMm"`
+	MaintenanceThresholdMM int64      `json:"maintenanceThresholdMm"`
+	MaintenanceDue         bool       `json:"maintenanceDue"`
+	LastServiceAt          *time.Time `json:"lastServiceAt,omitempty"`
+	// RollLengthMM is the length of the roll last loaded, 0 if never recorded;
+	// RollRemainingMM is what is left of it after what was fed since.
+	RollLengthMM    int64  `json:"rollLengthMm"`
+	RollRemainingMM *int64 `json:"rollRemainingMm,omitempty"`
+}
+
+func initPrinterStats() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS printer_stats (
+		name TEXT PRIMARY KEY,
+		labels INTEGER NOT NULL DEFAULT 0,
+		lengthMm INTEGER NOT NULL DEFAULT 0,
+		sinceServiceMm INTEGER NOT NULL DEFAULT 0,
+		thresholdMm INTEGER NOT NULL DEFAULT 0,
+		alerted INTEGER NOT NULL DEFAULT 0,
+		lastServiceAt DATETIME
+	);`)
+	if err != nil {
+		return err
+	}
+	for _, col := range []string{"rollMm", "rollFedMm"} {
+		if err := ensureColumn("printer_stats", col, "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return fmt.Errorf("migrate printer_stats.%s: %w", col, err)
+		}
+	}
+	return nil
+}
+
+// recordPrint adds a finished run to the printer's counters and raises a
+// maintenance alert the first time the since-service length crosses the
+// configured threshold. It reports whether this run crossed it.
+func recordPrint(name string, labels, lengthMM int) (maintenanceDue bool) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	_, err := db.Exec(
+		`INSERT INTO printer_stats (name, labels, lengthMm, sinceServiceMm, rollFedMm) VALUES (?, ?, ?, ?, ?)
+		 ON CONFLICT(name) DO UPDATE SET
+		   labels = labels + excluded.labels,
+		   lengthMm = lengthMm + excluded.lengthMm,
+		   sinceServiceMm = sinceServiceMm + excluded.sinceServiceMm,
+		   rollFedMm = rollFedMm + excluded.rollFedMm`,
+		name, labels, lengthMM, lengthMM, lengthMM,
+	)
+	if err != nil {
+		log.Printf("Printer %s: stats update error: %v", name, err)
+		return false
+	}
+
+	res, err := db.Exec(
+		`UPDATE printer_stats SET alerted = 1
+		 WHERE name = ? AND alerted = 0 AND thresholdMm > 0 AND sinceServiceMm >= thresholdMm`,
+		name,
+	)
+	if err != nil {
+		log.Printf("Printer %s: stats update error: %v", name, err)
+		return false
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("⚠️ Printer %s: maintenance threshold reached, print head needs cleaning or replacement", name)
+		return true
+	}
+	return false
+}
+
+func loadPrinterStats(name string) (PrinterStats, error) {
+	st := PrinterStats{Name: name}
+	var lastService sql.NullTime
+	var rollFed int64
+	err := db.QueryRow(
+		`SELECT labels, lengthMm, sinceServiceMm, thresholdMm, lastServiceAt, rollMm, rollFedMm FROM printer_stats WHERE name = ?`,
+		name,
+	).Scan(&st.LabelsPrinted, &st.LengthPrintedMM, &st.LengthSinceServiceMM, &st.MaintenanceThresholdMM, &lastService, &st.RollLengthMM, &rollFed)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return st, err
+	}
+	if lastService.Valid {
+		st.LastServiceAt = &lastService.Time
+	}
+	st.MaintenanceDue = st.MaintenanceThresholdMM > 0 && st.LengthSinceServiceMM >= st.MaintenanceThresholdMM
+	if st.RollLengthMM > 0 {
+		remaining := max(st.RollLengthMM-rollFed, 0)
+		st.RollRemainingMM = &remaining
+	}
+	return st, nil
+}
+
+func printerInfoHandler(c echo.Context) error {
+	vid, pid, ok := tsplprinter.ParsePrinterName(c.Param("name"))
+	if !ok {
+		return apiError(c, http.StatusBadRequest, ErrInvalidPrinterName, "printer name must be vid:pid in hex, e.g. 0fe6:8800")
+	}
+	name := tsplprinter.PrinterName(vid, pid)
+	st, err := loadPrinterStats(name)
+	if err != nil {
+		return apiError(c, http.StatusInternalServerError, ErrInternal, "Error fetching printer stats")
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"name":         name,
+		"connected":    tsplprinter.CheckPrinterDevice(vid, pid) == nil,
+		"capabilities": tsplprinter.LookupCapabilities(vid, pid),
+		"stats":        st,
+	})
+}
+
+type MaintenanceRequest struct {
+	ThresholdMM  *int64 `json:"thresholdMm"`
+	Serviced     bool   `json:"serviced"`
+	RollLengthMM *int64 `json:"rollLengthMm"`
+}
+
+// maintenanceHandler sets the head-wear threshold (0 disables the alert)
+// and/or records that the head was cleaned or replaced, which restarts the
+// since-service counter, and/or that a roll of RollLengthMM was loaded, which
+// restarts the roll's fed length (0 stops tracking the roll).
+func maintenanceHandler(c echo.Context) error {
+	vid, pid, ok := tsplprinter.ParsePrinterName(c.Param("name"))
+	if !ok {
+		return apiError(c, http.StatusBadRequest, ErrInvalidPrinterName, "printer name must be vid:pid in hex, e.g. 0fe6:8800")
+	}
+	name := tsplprinter.PrinterName(vid, pid)
+	var req MaintenanceRequest
+	if err := c.Bind(&req); err != nil {
+		return apiError(c, http.StatusBadRequest, ErrInvalidJSON, "Invalid JSON")
+	}
+	if req.ThresholdMM != nil && *req.ThresholdMM < 0 {
+		return apiError(c, http.StatusBadRequest, ErrInvalidRequest, "thresholdMm must not be negative")
+	}
+	if req.RollLengthMM != nil && *req.RollLengthMM < 0 {
+		return apiError(c, http.StatusBadRequest, ErrInvalidRequest, "rollLengthMm must not be negative")
+	}
+
+	dbMu.Lock()
+	err := func() error {
+		if _, err := db.Exec(`INSERT OR IGNORE INTO printer_stats (name) VALUES (?)`, name); err != nil {
+			return err
+		}
+		if req.ThresholdMM != nil {
+			if _, err := db.Exec(`UPDATE printer_stats SET thresholdMm = ?, alerted = 0 WHERE name = ?`, *req.ThresholdMM, name); err != nil {
+				return err
+			}
+		}
+		if req.Serviced {
+			if _, err := db.Exec(
+				`UPDATE printer_stats SET sinceServiceMm = 0, alerted = 0, lastServiceAt = ? WHERE name = ?`,
+				time.Now(), name,
+			); err != nil {
+				return err
+			}
+		}
+		if req.RollLengthMM != nil {
+			if _, err := db.Exec(`UPDATE printer_stats SET rollMm = ?, rollFedMm = 0 WHERE name = ?`, *req.RollLengthMM, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	dbMu.Unlock()
+	if err != nil {
+		return apiError(c, http.StatusInternalServerError, ErrInternal, "Error updating printer maintenance")
+	}
+
+	st, err := loadPrinterStats(name)
+	if err != nil {
+		return apiError(c, http.StatusInternalServerError, ErrInternal, "Error fetching printer stats")
+	}
+	return c.JSON(http.StatusOK, st)
+}
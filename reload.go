@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ConfigPollInterval is how often watchConfig checks ConfigPath for changes.
+const ConfigPollInterval = 5 * time.Second
+
+var reloadMu sync.Mutex
+
+// reloadConfig loads ConfigPath again and applies it. Hooks, quotas, alerts
+// and printer defaults are read per job, so they take effect on the next job;
+// the worker pool is resized here. The IPP listener and the scale listen and
+// connect addresses keep their startup settings until the service restarts.
+func reloadConfig() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	old := currentConfig()
+	if err := loadConfig(); err != nil {
+		return err
+	}
+	cfg := currentConfig()
+	setWorkerCount(cfg.Workers)
+	if cfg.IPP != old.IPP {
+		log.Printf("Config: ipp settings changed, restart the service to apply them")
+	}
+	if cfg.Scale.Listen != old.Scale.Listen || cfg.Scale.Connect != old.Scale.Connect {
+		log.Printf("Config: scale listen or connect address changed, restart the service to apply it")
+	}
+	log.Printf("Config reloaded from %s (%d workers)", ConfigPath, cfg.Workers)
+	return nil
+}
+
+// watchConfig reloads the configuration whenever ConfigPath's modification
+// time or size changes. A file that fails to load is logged and the
+// previous configuration stays in effect.
+func watchConfig() {
+	last := configStamp()
+	for {
+		time.Sleep(ConfigPollInterval)
+		stamp := configStamp()
+		if stamp == last {
+			continue
+		}
+		last = stamp
+		if err := reloadConfig(); err != nil {
+			log.Printf("Config reload error: %v", err)
+		}
+	}
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func configStamp() fileStamp {
+	fi, err := os.Stat(ConfigPath)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{fi.ModTime(), fi.Size()}
+}
+
+func reloadConfigHandler(c echo.Context) error {
+	if err := reloadConfig(); err != nil {
+		return apiError(c, http.StatusBadRequest, ErrInvalidConfig, err.Error())
+	}
+	cfg := currentConfig()
+	return c.JSON(http.StatusOK, echo.Map{"status": "reloaded", "workers": cfg.Workers, "defaults": cfg.Defaults})
+}
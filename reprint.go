@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"barcode-pos/tsplprinter"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mattn/go-sqlite3"
+)
+
+const ShortCodeLength = 6
+
+// shortCodeAlphabet leaves out characters that are easily misread on a small
+// thermal print (0/O, 1/I/L, U/V).
+const shortCodeAlphabet = "23456789ABCDEFGHJKMNPQRSTWXYZ"
+
+func newShortCode() (string, error) {
+	base := big.NewInt(int64(len(shortCodeAlphabet)))
+	code := make([]byte, ShortCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, base)
+		if err != nil {
+			return "", err
+		}
+		code[i] = shortCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+func isUniqueViolation(err error) bool {
+	var serr sqlite3.Error
+	return errors.As(err, &serr) && serr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// reprintHandler enqueues a fresh copy of the job identified by the short
+// code printed on (or returned for) the original label. One unit is printed
+// unless ?count= says otherwise.
+func reprintHandler(c echo.Context) error {
+	shortCode := strings.ToUpper(strings.TrimSpace(c.Param("shortCode")))
+
+	var id int64
+	var redacted bool
+	err := db.QueryRow(
+		`SELECT id, redactedAt IS NOT NULL FROM jobs WHERE shortCode = ?`, shortCode,
+	).Scan(&id, &redacted)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apiError(c, http.StatusNotFound, ErrJobNotFound, "Job not found")
+		}
+		return apiError(c, http.StatusInternalServerError, ErrInternal, "Error fetching job")
+	}
+	if redacted {
+		return apiError(c, http.StatusGone, ErrJobErased, "Job content was erased")
+	}
+	orig, err := loadJob(id)
+	if err != nil || orig == nil {
+		return apiError(c, http.StatusInternalServerError, ErrInternal, "Error fetching job")
+	}
+
+	req := orig.Request
+	req.PrintCount = 1
+	if s := c.QueryParam("count"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > MaxPrintCount {
+			return apiError(c, http.StatusBadRequest, ErrInvalidRequest, fmt.Sprintf("count must be between 1 and %d", MaxPrintCount))
+		}
+		req.PrintCount = n
+	}
+
+	src := requestSource(c, "")
+	if err := tsplprinter.CheckPrinterDevice(req.VID, req.PID); err != nil {
+		return apiError(c, http.StatusBadRequest, ErrPrinterOffline, fmt.Sprintf("Printer device not found, please check connected or not: %s", err))
+	}
+
+	newID, newCode, err := insertJob(req, src)
+	if isQuotaError(err) {
+		return apiErrorFrom(c, ErrQuotaExceeded, err)
+	}
+	if err != nil {
+		return apiError(c, http.StatusInternalServerError, ErrInternal, "Failed to enqueue job")
+	}
+	return c.JSON(http.StatusAccepted, echo.Map{
+		"jobId":     newID,
+		"shortCode": newCode,
+		"reprintOf": orig.ID,
+		"status":    StatusPending,
+	})
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"barcode-pos/tsplprinter"
+)
+
+const (
+	// MaxScalePrice is the largest price, in cents, a 5-digit price field holds.
+	MaxScalePrice = 99999
+	// ScaleIdleTimeout closes a scale connection that sends nothing for this
+	// long, so a peer that vanished without closing doesn't hold it forever.
+	ScaleIdleTimeout = 15 * time.Minute
+	// ScaleRedialDelay is the pause before Connect is dialed again after the
+	// connection failed or closed.
+	ScaleRedialDelay = 5 * time.Second
+
+	ScaleFormatPLU  = "plu"
+	ScaleFormatSICS = "sics"
+)
+
+// ScaleConfig connects weighing scales over TCP, one reading per line in one
+// of two formats:
+//
+//   - "plu" (default): "<PLU>,<weight>" (comma, semicolon or spaces
+//     between), the weight in kg, or in grams with a "g" suffix, e.g.
+//     "123,0.456" or "123 456g". Each line is answered with "OK <shortCode>"
+//     or "ERR <code> <message>".
+//   - "sics": Mettler Toledo MT-SICS weight responses as sent by the print
+//     key or the S/SI/SIR commands, e.g. "S S      0.456 kg". Only stable
+//     weights ("S S") print, always as item PLU, which suits prepacking one
+//     product at a time. A weight prints once; SIR repeats it, so the next
+//     label prints only after the pan returns to zero or the stable weight
+//     changes. Nothing is sent back, as the scale would read it as a command.
+//
+// The reading is priced from Items and printed as a price-embedded EAN-13
+// label: Prefix (2 digits), PLU (5), price in cents (5) and the check digit
+// the printer adds.
+//
+// Scales with a network port dial Listen. For a scale on a serial port, run
+// a serial-to-TCP server such as ser2net next to it and set Connect to its
+// address; the service dials it and redials whenever the connection drops.
+// socat can bridge the other way instead, e.g.
+// "socat /dev/ttyUSB0,b9600,raw TCP:localhost:4001" into Listen.
+type ScaleConfig struct {
+	Listen     string               `json:"listen"`  // e.g. ":4001"; empty disables the listener
+	Connect    string               `json:"connect"` // e.g. "ser2net-host:3001"; empty dials nothing
+	Format     string               `json:"format"`  // "plu" or "sics"; empty means "plu"
+	PLU        string               `json:"plu"`     // item printed for "sics" readings
+	VID        string               `json:"vid"`     // printer; empty uses the defaults
+	PID        string               `json:"pid"`
+	Prefix     string               `json:"prefix"`     // GS1 in-store prefix, "20"-"29"; empty means "20"
+	TerminalID string               `json:"terminalId"` // reported as the job's terminal; empty means "scale"
+	Items      map[string]ScaleItem `json:"items"`      // by PLU
+}
+
+// ScaleItem is a weighed product.
+type ScaleItem struct {
+	Name       string `json:"name"`
+	PricePerKg int    `json:"pricePerKg"` // cents
+}
+
+var (
+	scalePrefixRe  = regexp.MustCompile(`^2[0-9]$`)
+	scalePLURe     = regexp.MustCompile(`^[0-9]{1,5}$`)
+	scaleReadingRe = regexp.MustCompile(`^\s*([0-9]{1,5})\s*[,; ]\s*([0-9]+(?:\.[0-9]+)?)\s*(kg|g)?\s*$`)
+	// sicsWeightRe matches an MT-SICS weight response: command, status
+	// (S stable, D dynamic), weight and unit.
+	sicsWeightRe = regexp.MustCompile(`^(S|SI|SIR)\s+([SD])\s+(-?[0-9]+(?:\.[0-9]+)?)\s+(kg|g)\s*$`)
+
+	// errUnstableWeight marks SICS lines that carry no weight to print:
+	// dynamic weights and the scale's other status responses.
+	errUnstableWeight = errors.New("weight is not stable")
+)
+
+func (s *ScaleConfig) validate() error {
+	switch s.Format {
+	case "", ScaleFormatPLU:
+	case ScaleFormatSICS:
+		if _, ok := s.Items[s.PLU]; !ok {
+			return errors.New(`plu must name one of items when format is "sics"`)
+		}
+	default:
+		return fmt.Errorf("format must be %q or %q", ScaleFormatPLU, ScaleFormatSICS)
+	}
+	if s.Prefix != "" && !scalePrefixRe.MatchString(s.Prefix) {
+		return errors.New("prefix must be two digits from 20 to 29")
+	}
+	for plu, item := range s.Items {
+		if !scalePLURe.MatchString(plu) {
+			return fmt.Errorf("items: PLU %q must be 1 to 5 digits", plu)
+		}
+		if item.PricePerKg <= 0 {
+			return fmt.Errorf("items.%s: pricePerKg must be positive", plu)
+		}
+	}
+	return nil
+}
+
+// startScaleListener accepts scale connections on listen. Items and printer
+// settings are read from the current config for every reading, so price
+// changes apply without a restart.
+func startScaleListener(listen string) {
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		log.Printf("Scale listener failed: %v", err)
+		return
+	}
+	log.Printf("Starting scale listener on %s", listen)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				log.Printf("Scale listener failed: %v", err)
+				return
+			}
+			go serveScale(conn)
+		}
+	}()
+}
+
+// startScaleDialer keeps a connection to addr, e.g. a ser2net port with a
+// serial scale behind it, redialing after failures.
+func startScaleDialer(addr string) {
+	log.Printf("Connecting to scale at %s", addr)
+	go func() {
+		for {
+			conn, err := net.DialTimeout("tcp", addr, ScaleRedialDelay)
+			if err != nil {
+				log.Printf("Scale %s: %v", addr, err)
+			} else {
+				serveScale(conn)
+			}
+			time.Sleep(ScaleRedialDelay)
+		}
+	}()
+}
+
+// serveScale reads readings off one connection and, for the "plu" format,
+// answers each line with "OK <shortCode>" or "ERR <code> <message>".
+func serveScale(conn net.Conn) {
+	defer conn.Close()
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	sc := bufio.NewScanner(conn)
+	var trigger sicsTrigger
+	for {
+		conn.SetReadDeadline(time.Now().Add(ScaleIdleTimeout))
+		if !sc.Scan() {
+			break
+		}
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		sics := currentConfig().Scale.Format == ScaleFormatSICS
+		if sics && !trigger.fire(line) {
+			continue
+		}
+		shortCode, err := scaleReading(line, host)
+		if err != nil {
+			code := ErrInvalidRequest
+			var ce *codedError
+			if errors.As(err, &ce) {
+				code = ce.code
+			}
+			log.Printf("Scale %s: %q rejected: %v", host, line, err)
+			if !sics {
+				fmt.Fprintf(conn, "ERR %s %s\r\n", code, err)
+			}
+			continue
+		}
+		if !sics {
+			fmt.Fprintf(conn, "OK %s\r\n", shortCode)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		log.Printf("Scale %s: %v", host, err)
+	}
+}
+
+// sicsTrigger picks the SICS lines that print. SIR sends the current weight
+// over and over, so a stable weight fires once; the next fires when the pan
+// has returned to zero (or below) or the stable weight changed. Everything
+// else, including the idle zero stream, is dropped without logging.
+type sicsTrigger struct {
+	last string // weight and unit last fired, "" once the pan is empty
+}
+
+func (t *sicsTrigger) fire(line string) bool {
+	m := sicsWeightRe.FindStringSubmatch(line)
+	if m == nil || m[2] != "S" {
+		return false
+	}
+	if kg, _ := strconv.ParseFloat(m[3], 64); kg <= 0 {
+		t.last = ""
+		return false
+	}
+	weight := m[3] + m[4]
+	if weight == t.last {
+		return false
+	}
+	t.last = weight
+	return true
+}
+
+// parseScaleReading returns the PLU and the weight, in kg, of one line.
+func parseScaleReading(cfg ScaleConfig, line string) (string, float64, error) {
+	var plu, weight, unit string
+	if cfg.Format == ScaleFormatSICS {
+		m := sicsWeightRe.FindStringSubmatch(line)
+		if m == nil || m[2] != "S" {
+			return "", 0, errUnstableWeight
+		}
+		plu, weight, unit = cfg.PLU, m[3], m[4]
+	} else {
+		m := scaleReadingRe.FindStringSubmatch(line)
+		if m == nil {
+			return "", 0, codedErrorf(ErrInvalidRequest, "reading must be <PLU>,<weight>")
+		}
+		plu, weight, unit = m[1], m[2], m[3]
+	}
+	kg, _ := strconv.ParseFloat(weight, 64)
+	if unit == "g" {
+		kg /= 1000
+	}
+	return plu, kg, nil
+}
+
+// scaleReading prices one reading and enqueues its label.
+func scaleReading(line, clientIP string) (string, error) {
+	cfg := currentConfig().Scale
+	pluText, weight, err := parseScaleReading(cfg, line)
+	if err != nil {
+		return "", err
+	}
+	plu, _ := strconv.Atoi(pluText)
+	item, ok := cfg.Items[pluText]
+	if !ok {
+		item, ok = cfg.Items[strconv.Itoa(plu)]
+	}
+	if !ok {
+		return "", codedErrorf(ErrUnknownPLU, "PLU %d is not configured", plu)
+	}
+	if weight <= 0 {
+		return "", codedErrorf(ErrInvalidRequest, "weight must be positive")
+	}
+	price := int(math.Round(weight * float64(item.PricePerKg)))
+	if price > MaxScalePrice {
+		return "", codedErrorf(ErrInvalidRequest, "price %d.%02d does not fit the barcode", price/100, price%100)
+	}
+
+	prefix := cfg.Prefix
+	if prefix == "" {
+		prefix = "20"
+	}
+	req := PrintRequest{
+		VID:         cfg.VID,
+		PID:         cfg.PID,
+		TopText:     fmt.Sprintf("%s %.3fkg %d.%02d", item.Name, weight, price/100, price%100),
+		BarcodeData: fmt.Sprintf("%s%05d%05d", prefix, plu, price),
+		Symbology:   tsplprinter.SymEAN13,
+		PrintCount:  1,
+	}
+	applyDefaults(&req, currentConfig().Defaults)
+	if err := validateRequest(&req); err != nil {
+		return "", err
+	}
+
+	src := JobSource{ClientIP: clientIP, TerminalID: cfg.TerminalID}
+	if src.TerminalID == "" {
+		src.TerminalID = "scale"
+	}
+	if err := tsplprinter.CheckPrinterDevice(req.VID, req.PID); err != nil {
+		return "", codedErrorf(ErrPrinterOffline, "printer device not found: %s", err)
+	}
+	_, shortCode, err := insertJob(req, src)
+	if isQuotaError(err) {
+		return "", err
+	}
+	if err != nil {
+		return "", codedErrorf(ErrInternal, "failed to enqueue job")
+	}
+	return shortCode, nil
+}
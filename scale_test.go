@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseScaleReading(t *testing.T) {
+	plu := ScaleConfig{}
+	sics := ScaleConfig{Format: ScaleFormatSICS, PLU: "123"}
+	tests := []struct {
+		name    string
+		cfg     ScaleConfig
+		line    string
+		plu     string
+		kg      float64
+		wantErr error
+	}{
+		{"plu kg", plu, "123,0.456", "123", 0.456, nil},
+		{"plu grams", plu, "123 456g", "123", 0.456, nil},
+		{"plu semicolon", plu, "7;1.5kg", "7", 1.5, nil},
+		{"plu garbage", plu, "S S 0.456 kg", "", 0, errInvalid},
+		{"sics stable", sics, "S S      0.456 kg", "123", 0.456, nil},
+		{"sics stable grams", sics, "S S    456.0 g", "123", 0.456, nil},
+		{"sics SI", sics, "SI S 1.250 kg", "123", 1.25, nil},
+		{"sics dynamic", sics, "S D      0.452 kg", "", 0, errUnstableWeight},
+		{"sics overload", sics, "S +", "", 0, errUnstableWeight},
+		{"sics plu line", sics, "123,0.456", "", 0, errUnstableWeight},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plu, kg, err := parseScaleReading(tt.cfg, tt.line)
+			switch {
+			case tt.wantErr == errInvalid:
+				var ce *codedError
+				if !errors.As(err, &ce) || ce.code != ErrInvalidRequest {
+					t.Fatalf("err = %v, want %s", err, ErrInvalidRequest)
+				}
+				return
+			case !errors.Is(err, tt.wantErr):
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if plu != tt.plu || kg != tt.kg {
+				t.Errorf("got %s, %v kg, want %s, %v kg", plu, kg, tt.plu, tt.kg)
+			}
+		})
+	}
+}
+
+// errInvalid stands for a reading rejected with ErrInvalidRequest.
+var errInvalid = errors.New("invalid")
+
+func TestSICSTrigger(t *testing.T) {
+	// An SIR stream: idle zeros, a package settling, repeats, more product,
+	// removal and the next package
+	stream := []struct {
+		line string
+		fire bool
+	}{
+		{"S S      0.000 kg", false},
+		{"S S      0.000 kg", false},
+		{"S D      0.312 kg", false},
+		{"S S      0.456 kg", true},
+		{"S S      0.456 kg", false},
+		{"S S      0.456 kg", false},
+		{"S D      0.501 kg", false},
+		{"S S      0.512 kg", true},
+		{"S S      0.512 kg", false},
+		{"S S     -0.002 kg", false},
+		{"S S      0.000 kg", false},
+		{"S S      0.512 kg", true},
+		{"S +", false},
+	}
+	var trig sicsTrigger
+	for i, s := range stream {
+		if got := trig.fire(s.line); got != s.fire {
+			t.Errorf("line %d %q: fire = %v, want %v", i, s.line, got, s.fire)
+		}
+	}
+}
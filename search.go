@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// barcodeSearchKey keys the barcode digests when payload encryption is on, so
+// the index doesn't reveal barcode content that the jobs table keeps sealed.
+var barcodeSearchKey []byte
+
+// barcodeDigest is the indexed form of a barcode. The scheme prefix lets
+// initBarcodeIndex spot digests written before a key file was added or
+// under a different key.
+func barcodeDigest(data string) string {
+	if barcodeSearchKey == nil {
+		sum := sha256.Sum256([]byte(data))
+		return digestScheme() + hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, barcodeSearchKey)
+	mac.Write([]byte(data))
+	return digestScheme() + hex.EncodeToString(mac.Sum(nil))
+}
+
+// digestScheme is "sha256:" without a key, or "hmac:<fp>:" where fp
+// fingerprints the search key without revealing it.
+func digestScheme() string {
+	if barcodeSearchKey == nil {
+		return "sha256:"
+	}
+	mac := hmac.New(sha256.New, barcodeSearchKey)
+	mac.Write([]byte("barcode-search-fingerprint"))
+	return "hmac:" + hex.EncodeToString(mac.Sum(nil)[:4]) + ":"
+}
+
+// jobBarcodes lists the barcodes a job prints. Raw jobs are opaque and have none.
+func jobBarcodes(req PrintRequest) []string {
+	if req.RawFormat != "" {
+		return nil
+	}
+	codes := []string{req.BarcodeData}
+	for _, spec := range req.Set {
+		codes = append(codes, spec.BarcodeData)
+	}
+	return codes
+}
+
+// initBarcodeIndex creates the barcode search index and fills it in for jobs
+// it doesn't cover yet: jobs from before the index existed, or all of them
+// after the key file changed. Redacted jobs are never indexed.
+func initBarcodeIndex() error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS job_barcodes (
+		digest TEXT NOT NULL,
+		jobId INTEGER NOT NULL,
+		PRIMARY KEY (digest, jobId)
+	);`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DELETE FROM job_barcodes WHERE digest NOT LIKE ?`, digestScheme()+"%"); err != nil {
+		return err
+	}
+
+	rows, err := db.Query(`SELECT ` + jobColumns + ` FROM jobs
+		WHERE redactedAt IS NULL AND id NOT IN (SELECT jobId FROM job_barcodes)`)
+	if err != nil {
+		return err
+	}
+	var jobs []*Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			if job == nil {
+				rows.Close()
+				return err
+			}
+			log.Printf("Barcode index: skipping job %d: %v", job.ID, err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		if err := indexBarcodes(int64(job.ID), job.Request); err != nil {
+			return err
+		}
+	}
+	if len(jobs) > 0 {
+		log.Printf("Barcode index: indexed %d existing jobs", len(jobs))
+	}
+	return nil
+}
+
+// indexBarcodes adds a job's barcodes to the search index. Callers writing
+// the jobs table hold dbMu already.
+func indexBarcodes(jobID int64, req PrintRequest) error {
+	for _, code := range jobBarcodes(req) {
+		if _, err := db.Exec(`INSERT OR IGNORE INTO job_barcodes (digest, jobId) VALUES (?, ?)`, barcodeDigest(code), jobID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BarcodeHit is one job that printed the searched barcode.
+type BarcodeHit struct {
+	JobSummary
+	Copies int `json:"copies"` // labels of this barcode in the job
+}
+
+// searchJobsHandler finds the jobs that printed a barcode, newest first, with
+// where and when they printed and how many copies. Jobs redacted on request
+// (DELETE /jobs/by-subject) are no longer findable, and neither are raw
+// TSPL/ZPL jobs since their content isn't parsed.
+func searchJobsHandler(c echo.Context) error {
+	barcode := strings.TrimSpace(c.QueryParam("barcode"))
+	if barcode == "" {
+		return apiError(c, http.StatusBadRequest, ErrInvalidRequest, "barcode is required")
+	}
+
+	rows, err := db.Query(
+		`SELECT `+jobColumns+` FROM jobs
+		 WHERE id IN (SELECT jobId FROM job_barcodes WHERE digest = ?) AND redactedAt IS NULL
+		 ORDER BY id DESC`,
+		barcodeDigest(barcode),
+	)
+	if err != nil {
+		return apiError(c, http.StatusInternalServerError, ErrInternal, "Error searching jobs")
+	}
+	defer rows.Close()
+
+	hits := []BarcodeHit{}
+	printed := 0
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return apiError(c, http.StatusInternalServerError, ErrInternal, "Error reading jobs")
+		}
+		copies := 0
+		for _, code := range jobBarcodes(job.Request) {
+			if code == barcode {
+				copies += job.Request.PrintCount
+			}
+		}
+		if job.Status == StatusDone {
+			printed += copies
+		}
+		hits = append(hits, BarcodeHit{JobSummary: summarize(job), Copies: copies})
+	}
+	if err := rows.Err(); err != nil {
+		return apiError(c, http.StatusInternalServerError, ErrInternal, "Error searching jobs")
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"barcode":       barcode,
+		"copiesPrinted": printed,
+		"jobs":          hits,
+	})
+}
+
+// deriveSearchKey derives the barcode index key from the payload key, so the
+// two are never the same bytes.
+func deriveSearchKey(payloadKey []byte) []byte {
+	mac := hmac.New(sha256.New, payloadKey)
+	mac.Write([]byte("barcode-search-v1"))
+	return mac.Sum(nil)
+}
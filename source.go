@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"barcode-pos/tsplprinter"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	HeaderAPIKey     = "X-API-Key"
+	HeaderTerminalID = "X-Terminal-ID"
+	HeaderStoreID    = "X-Store-ID"
+
+	MaxTerminalIDLength = 64
+	DefaultListLimit    = 50
+	MaxListLimit        = 500
+)
+
+// JobSource records which client enqueued a job. The API key is stored as a
+// short fingerprint, never in the clear.
+type JobSource struct {
+	APIKey     string `json:"apiKey,omitempty"`
+	ClientIP   string `json:"clientIp"`
+	UserAgent  string `json:"userAgent,omitempty"`
+	TerminalID string `json:"terminalId,omitempty"`
+}
+
+// QuotaConfig caps the labels a terminal may enqueue per calendar day.
+// Terminals maps a terminal ID to its own limit; others get
+// TerminalDailyLabels. Zero means unlimited. Jobs without a terminal ID are
+// not subject to quotas.
+type QuotaConfig struct {
+	TerminalDailyLabels int            `json:"terminalDailyLabels"`
+	Terminals           map[string]int `json:"terminals"`
+}
+
+func (q QuotaConfig) limit(terminalID string) int {
+	if n, ok := q.Terminals[terminalID]; ok {
+		return n
+	}
+	return q.TerminalDailyLabels
+}
+
+func requestSource(c echo.Context, terminalID string) JobSource {
+	if terminalID == "" {
+		terminalID = c.Request().Header.Get(HeaderTerminalID)
+	}
+	if len(terminalID) > MaxTerminalIDLength {
+		terminalID = terminalID[:MaxTerminalIDLength]
+	}
+	return JobSource{
+		APIKey:     keyFingerprint(c.Request().Header.Get(HeaderAPIKey)),
+		ClientIP:   c.RealIP(),
+		UserAgent:  c.Request().UserAgent(),
+		TerminalID: terminalID,
+	}
+}
+
+func keyFingerprint(key string) string {
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "key:" + hex.EncodeToString(sum[:4])
+}
+
+// checkQuota rejects a job that would take the terminal past its daily label
+// quota. The caller must hold dbMu until the job is inserted.
+func checkQuota(terminalID string, labels int) error {
+	if terminalID == "" {
+		return nil
+	}
+	limit := currentConfig().Quotas.limit(terminalID)
+	if limit <= 0 {
+		return nil
+	}
+	now := time.Now()
+	startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	var used int
+	err := db.QueryRow(
+		`SELECT COALESCE(SUM(labelCount), 0) FROM jobs WHERE terminalId = ? AND createdAt >= ?`,
+		terminalID, startOfDay,
+	).Scan(&used)
+	if err != nil {
+		return codedErrorf(ErrInternal, "could not check quota for terminal %s", terminalID)
+	}
+	if used+labels > limit {
+		return codedErrorf(ErrQuotaExceeded, "terminal %s daily quota exceeded: %d of %d labels used, job needs %d", terminalID, used, limit, labels)
+	}
+	return nil
+}
+
+// isQuotaError reports whether err is checkQuota rejecting the job.
+func isQuotaError(err error) bool {
+	var ce *codedError
+	return errors.As(err, &ce) && ce.code == ErrQuotaExceeded
+}
+
+type JobSummary struct {
+	ID                int       `json:"id"`
+	ShortCode         string    `json:"shortCode"`
+	Status            string    `json:"status"`
+	Warning           string    `json:"warning,omitempty"`
+	Printer           string    `json:"printer"`
+	PrintCount        int       `json:"printCount"`
+	Labels            int       `json:"labels"`
+	Attempts          int       `json:"attempts"`
+	Priority          int       `json:"priority"`
+	EffectivePriority int       `json:"effectivePriority"`
+	Source            JobSource `json:"source"`
+	CreatedAt         time.Time `json:"createdAt"`
+	UpdatedAt         time.Time `json:"updatedAt"`
+}
+
+func summarize(job *Job) JobSummary {
+	labels := job.LabelCount
+	if labels == 0 {
+		// Jobs enqueued before labelCount was stored
+		labels = job.Request.LabelCount()
+	}
+	return JobSummary{
+		ID:                job.ID,
+		ShortCode:         job.ShortCode,
+		Status:            job.Status,
+		Warning:           job.Warning,
+		Printer:           tsplprinter.PrinterName(job.Request.VID, job.Request.PID),
+		PrintCount:        job.Request.PrintCount,
+		Labels:            labels,
+		Attempts:          job.Attempts,
+		Priority:          job.Request.priority(),
+		EffectivePriority: job.EffectivePriority,
+		Source:            job.Source,
+		CreatedAt:         job.CreatedAt,
+		UpdatedAt:         job.UpdatedAt,
+	}
+}
+
+// listJobsHandler lists the most recent jobs, newest first, optionally
+// filtered by status, terminal or client IP.
+func listJobsHandler(c echo.Context) error {
+	limit := DefaultListLimit
+	if s := c.QueryParam("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > MaxListLimit {
+			return apiError(c, http.StatusBadRequest, ErrInvalidRequest, fmt.Sprintf("limit must be between 1 and %d", MaxListLimit))
+		}
+		limit = n
+	}
+
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE 1 = 1`
+	var args []any
+	for param, col := range map[string]string{"status": "status", "terminal": "terminalId", "ip": "clientIP"} {
+		if v := c.QueryParam(param); v != "" {
+			query += ` AND ` + col + ` = ?`
+			args = append(args, v)
+		}
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return apiError(c, http.StatusInternalServerError, ErrInternal, "Error listing jobs")
+	}
+	defer rows.Close()
+	jobs := []JobSummary{}
+	for rows.Next() {
+		// Payload errors don't matter here: summaries carry no label content.
+		job, _ := scanJob(rows)
+		if job == nil {
+			return apiError(c, http.StatusInternalServerError, ErrInternal, "Error listing jobs")
+		}
+		jobs = append(jobs, summarize(job))
+	}
+	if err := rows.Err(); err != nil {
+		return apiError(c, http.StatusInternalServerError, ErrInternal, "Error listing jobs")
+	}
+	return c.JSON(http.StatusOK, jobs)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"barcode-pos/tsplprinter"
+
+	"github.com/labstack/echo/v4"
+)
+
+// TenantBinding sets printer defaults for the requests of one store (the
+// X-Store-ID header) or one API key, so thin clients can send little more
+// than barcodeData and printCount. Exactly one of Store and APIKey is set.
+// Fields left empty in Defaults fall through to the global defaults.
+type TenantBinding struct {
+	Store    string          `json:"store"`
+	APIKey   string          `json:"apiKey"` // fingerprint as listed in job sources, e.g. "key:1a2b3c4d"
+	Defaults PrinterDefaults `json:"defaults"`
+}
+
+func (t *TenantBinding) validate() error {
+	if (t.Store == "") == (t.APIKey == "") {
+		return errors.New("exactly one of store or apiKey is required")
+	}
+	if t.APIKey != "" && !strings.HasPrefix(t.APIKey, "key:") {
+		return errors.New(`apiKey must be a key fingerprint such as "key:1a2b3c4d", not the key itself`)
+	}
+	return t.Defaults.validate()
+}
+
+func (d *PrinterDefaults) validate() error {
+	if d.SizeX < 0 || d.SizeX > MaxLabelWidthMM || d.SizeY < 0 || d.SizeY > MaxLabelHeightMM {
+		return codedErrorf(ErrInvalidLabelSize, "label size must be at most %dx%d mm", MaxLabelWidthMM, MaxLabelHeightMM)
+	}
+	if d.Density < 0 || d.Density > tsplprinter.MaxDensity {
+		return codedErrorf(ErrInvalidDensity, "density must be between 0 and %d", tsplprinter.MaxDensity)
+	}
+	if d.Symbology != "" && !slices.Contains(tsplprinter.Symbologies, strings.ToUpper(d.Symbology)) {
+		return codedErrorf(ErrInvalidSymbology, "symbology must be one of %s", strings.Join(tsplprinter.Symbologies, ", "))
+	}
+	return nil
+}
+
+// overlay returns d with every field set in o replaced.
+func (d PrinterDefaults) overlay(o PrinterDefaults) PrinterDefaults {
+	if o.VID != "" {
+		d.VID = o.VID
+	}
+	if o.PID != "" {
+		d.PID = o.PID
+	}
+	if o.SizeX != 0 {
+		d.SizeX = o.SizeX
+	}
+	if o.SizeY != 0 {
+		d.SizeY = o.SizeY
+	}
+	if o.Density != 0 {
+		d.Density = o.Density
+	}
+	if o.Symbology != "" {
+		d.Symbology = o.Symbology
+	}
+	return d
+}
+
+// requestDefaults resolves the defaults for a request: the global defaults,
+// overlaid by the API key's binding, overlaid by the store's binding.
+func requestDefaults(c echo.Context) PrinterDefaults {
+	cfg := currentConfig()
+	d := cfg.Defaults
+	key := keyFingerprint(c.Request().Header.Get(HeaderAPIKey))
+	store := strings.TrimSpace(c.Request().Header.Get(HeaderStoreID))
+	for _, t := range cfg.Tenants {
+		if t.APIKey != "" && t.APIKey == key {
+			d = d.overlay(t.Defaults)
+		}
+	}
+	for _, t := range cfg.Tenants {
+		if t.Store != "" && t.Store == store {
+			d = d.overlay(t.Defaults)
+		}
+	}
+	return d
+}
@@ -0,0 +1,34 @@
+package tsplprinter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Sound makes the printer beep repeat times using the TSPL SOUND command.
+// level is the volume (0-9) and interval the length of each beep in ms
+// (1-4095). TSPL has no command to drive the status LEDs directly, so
+// audible feedback is all the service can give.
+func Sound(vidHexStr, pidHexStr string, level, interval, repeat int) error {
+	if level < 0 || level > 9 {
+		return fmt.Errorf("sound level %d out of range 0-9", level)
+	}
+	if interval < 1 || interval > 4095 {
+		return fmt.Errorf("sound interval %d out of range 1-4095", interval)
+	}
+	if repeat < 1 {
+		repeat = 1
+	}
+
+	conn, err := openPrinter(vidHexStr, pidHexStr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	cmd := strings.Repeat(fmt.Sprintf("SOUND %d,%d\r\n", level, interval), repeat)
+	if err := writeChunked(conn.ep, []byte(cmd)); err != nil {
+		return fmt.Errorf("failed to write SOUND command: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package tsplprinter
+
+import (
+	"bytes"
+	"container/list"
+	"strings"
+	"sync"
+)
+
+// RenderCacheSize is the number of rendered command buffers kept in memory.
+const RenderCacheSize = 256
+
+type cacheEntry struct {
+	key Label
+	buf []byte
+}
+
+// renderCache is a small LRU of rendered command buffers, keyed by every
+// label field (variables, size, dpi, ...) except the ref code. The cache
+// lives in memory only, so a build that changes RenderLabel starts empty.
+type renderCache struct {
+	mu      sync.Mutex
+	order   *list.List
+	entries map[Label]*list.Element
+}
+
+var cache = &renderCache{
+	order:   list.New(),
+	entries: make(map[Label]*list.Element),
+}
+
+// renderCached returns the command buffer for a label, rendering it only on a
+// cache miss. The ref code differs for every job, so it is left out of the
+// key and spliced in before PRINT; reprints of a label still hit the cache.
+// The returned slice is shared and must not be modified.
+func renderCached(l Label) []byte {
+	key := l
+	key.RefCode = ""
+	buf := cache.get(key)
+	if l.RefCode == "" {
+		return buf
+	}
+
+	var ref strings.Builder
+	for _, el := range LabelLayout(l).Elements {
+		if el.Kind == ElemRefCode {
+			writeElement(&ref, el)
+		}
+	}
+	at := bytes.LastIndex(buf, []byte("PRINT "))
+	out := make([]byte, 0, len(buf)+ref.Len())
+	out = append(out, buf[:at]...)
+	out = append(out, ref.String()...)
+	return append(out, buf[at:]...)
+}
+
+func (rc *renderCache) get(l Label) []byte {
+	rc.mu.Lock()
+	if el, ok := rc.entries[l]; ok {
+		rc.order.MoveToFront(el)
+		rc.mu.Unlock()
+		return el.Value.(*cacheEntry).buf
+	}
+	rc.mu.Unlock()
+
+	buf := RenderLabel(l)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if _, ok := rc.entries[l]; !ok {
+		rc.entries[l] = rc.order.PushFront(&cacheEntry{key: l, buf: buf})
+		if rc.order.Len() > RenderCacheSize {
+			oldest := rc.order.Back()
+			rc.order.Remove(oldest)
+			delete(rc.entries, oldest.Value.(*cacheEntry).key)
+		}
+	}
+	return buf
+}
@@ -0,0 +1,47 @@
+package tsplprinter
+
+import (
+	"bytes"
+	"testing"
+)
+
+var benchLabel = Label{
+	SizeX: 45, SizeY: 35, TopText: "Organic Bananas 1kg", BarcodeData: "4006381333931",
+	Symbology: SymCode128, Copies: 1, Cut: true, RefCode: "7KQ2MX",
+}
+
+func TestRenderCachedMatchesRender(t *testing.T) {
+	l := benchLabel
+	for i := 0; i < 2; i++ { // miss, then hit
+		if got, want := renderCached(l), RenderLabel(l); !bytes.Equal(got, want) {
+			t.Fatalf("pass %d: cached buffer differs:\n%s\nwant:\n%s", i, got, want)
+		}
+	}
+	l.RefCode = "9ZZ3AB" // another job's reprint: same buffer, new ref code
+	if got, want := renderCached(l), RenderLabel(l); !bytes.Equal(got, want) {
+		t.Fatalf("ref code not spliced in:\n%s\nwant:\n%s", got, want)
+	}
+	key := l
+	key.RefCode = ""
+	if _, ok := cache.entries[key]; !ok || cache.order.Len() != 1 {
+		t.Fatalf("ref code is part of the cache key: %d entries", cache.order.Len())
+	}
+	l.BarcodeData = "4006381333932"
+	if got, want := renderCached(l), RenderLabel(l); !bytes.Equal(got, want) {
+		t.Fatalf("changed label served a stale buffer:\n%s", got)
+	}
+}
+
+func BenchmarkRenderLabel(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		RenderLabel(benchLabel)
+	}
+}
+
+func BenchmarkRenderCachedHit(b *testing.B) {
+	renderCached(benchLabel)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		renderCached(benchLabel)
+	}
+}
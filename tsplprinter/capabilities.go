@@ -0,0 +1,159 @@
+package tsplprinter
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Symbologies understood by RenderLabel.
+const (
+	SymCode128 = "128"
+	SymCode39  = "39"
+	SymEAN13   = "EAN13"
+	SymQR      = "QRCODE"
+	SymPDF417  = "PDF417"
+)
+
+// Symbologies lists every symbology RenderLabel can emit.
+var Symbologies = []string{SymCode128, SymCode39, SymEAN13, SymQR, SymPDF417}
+
+// Capabilities describes what a printer model can do.
+type Capabilities struct {
+	Model       string   `json:"model"`
+	MaxWidthMM  int      `json:"maxWidthMm"`  // widest printable label
+	DPI         int      `json:"dpi"`         // print head resolution
+	Symbologies []string `json:"symbologies"` // symbologies the firmware renders
+	Cutter      bool     `json:"cutter"`
+	Peeler      bool     `json:"peeler"`
+	MaxSpeedIPS int      `json:"maxSpeedIps"` // fastest supported print speed, inches per second
+}
+
+// DefaultCapabilities is assumed for printers without a descriptor.
+var DefaultCapabilities = Capabilities{
+	Model:       "generic TSPL",
+	MaxWidthMM:  108,
+	DPI:         203,
+	Symbologies: []string{SymCode128, SymCode39, SymEAN13},
+	Cutter:      true,
+	MaxSpeedIPS: 4,
+}
+
+// models maps "vid:pid" (lower-case hex, no 0x) to a capability descriptor.
+var models = map[string]Capabilities{
+	"0fe6:8800": {
+		Model:       "0fe6:8800 thermal label printer",
+		MaxWidthMM:  80,
+		DPI:         203,
+		Symbologies: []string{SymCode128, SymCode39, SymEAN13, SymQR},
+		Cutter:      true,
+		MaxSpeedIPS: 4,
+	},
+}
+
+// LookupCapabilities returns the descriptor for a printer, falling back to
+// DefaultCapabilities for unknown models or unparsable IDs.
+func LookupCapabilities(vidHexStr, pidHexStr string) Capabilities {
+	if c, ok := models[PrinterName(vidHexStr, pidHexStr)]; ok {
+		return c
+	}
+	return DefaultCapabilities
+}
+
+// PrinterName returns the canonical "vid:pid" name of a printer, e.g.
+// "0fe6:8800". Unparsable IDs are joined unchanged.
+func PrinterName(vidHexStr, pidHexStr string) string {
+	vid, pid, err := parseIDs(vidHexStr, pidHexStr)
+	if err != nil {
+		return vidHexStr + ":" + pidHexStr
+	}
+	return fmt.Sprintf("%04x:%04x", uint16(vid), uint16(pid))
+}
+
+// ParsePrinterName splits a name from PrinterName back into hex ID strings.
+func ParsePrinterName(name string) (vidHexStr, pidHexStr string, ok bool) {
+	vid, pid, found := strings.Cut(name, ":")
+	if !found {
+		return "", "", false
+	}
+	vidHexStr, pidHexStr = "0x"+vid, "0x"+pid
+	if _, _, err := parseIDs(vidHexStr, pidHexStr); err != nil {
+		return "", "", false
+	}
+	return vidHexStr, pidHexStr, true
+}
+
+// Supports reports whether the model renders the given symbology.
+func (c Capabilities) Supports(sym string) bool {
+	return slices.Contains(c.Symbologies, strings.ToUpper(sym))
+}
+
+// Adjust fits a label to the model, downgrading what it cannot do instead of
+// failing: oversized labels are narrowed, unsupported symbologies fall back to
+// CODE128 and excessive speeds are capped. It returns one warning per change,
+// or an error when the CODE128 fallback can't hold the data on the label.
+func (c Capabilities) Adjust(l *Label) ([]string, error) {
+	var warnings []string
+	if l.Symbology == "" {
+		l.Symbology = SymCode128
+	}
+	if c.MaxWidthMM > 0 && l.SizeX > c.MaxWidthMM {
+		warnings = append(warnings, fmt.Sprintf("label width %d mm exceeds %s maximum, reduced to %d mm", l.SizeX, c.Model, c.MaxWidthMM))
+		l.SizeX = c.MaxWidthMM
+	}
+	if !c.Supports(l.Symbology) {
+		if err := ValidateData(SymCode128, l.BarcodeData); err != nil {
+			return nil, fmt.Errorf("%s not supported by %s and %w", l.Symbology, c.Model, err)
+		}
+		if w, max := code128Width(len(l.BarcodeData)), l.SizeX*dotsPerMM(c.DPI); w > max {
+			return nil, fmt.Errorf("%s not supported by %s and as CODE128 it needs %d dots, the label has %d", l.Symbology, c.Model, w, max)
+		}
+		warnings = append(warnings, fmt.Sprintf("%s not supported by %s, printed as CODE128", l.Symbology, c.Model))
+		l.Symbology = SymCode128
+	}
+	if c.MaxSpeedIPS > 0 && l.Speed > c.MaxSpeedIPS {
+		warnings = append(warnings, fmt.Sprintf("speed %d ips exceeds %s maximum, reduced to %d ips", l.Speed, c.Model, c.MaxSpeedIPS))
+		l.Speed = c.MaxSpeedIPS
+	}
+	l.DPI = c.DPI
+	l.Cut = c.Cutter
+	return warnings, nil
+}
+
+// ValidateData reports whether data can be encoded in the symbology: EAN13
+// takes 12 digits, or 13 with a correct check digit; CODE39 (full ASCII)
+// and CODE128 take ASCII. QR and PDF417 take anything.
+func ValidateData(sym, data string) error {
+	switch strings.ToUpper(sym) {
+	case SymEAN13:
+		if len(data) != 12 && len(data) != 13 || strings.Trim(data, "0123456789") != "" {
+			return errors.New("EAN13 data must be 12 digits, or 13 with the check digit")
+		}
+		if len(data) == 13 && data[12] != ean13CheckDigit(data[:12]) {
+			return fmt.Errorf("EAN13 check digit must be %c", ean13CheckDigit(data[:12]))
+		}
+	case SymCode39, SymCode128, "":
+		if sym == "" {
+			sym = SymCode128
+		}
+		for _, r := range data {
+			if r < 0x20 || r > 0x7e {
+				return fmt.Errorf("CODE%s data must be printable ASCII, got %q", sym, r)
+			}
+		}
+	}
+	return nil
+}
+
+func ean13CheckDigit(digits string) byte {
+	sum := 0
+	for i := range 12 {
+		d := int(digits[i] - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return byte('0' + (10-sum%10)%10)
+}
@@ -0,0 +1,49 @@
+package tsplprinter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateData(t *testing.T) {
+	tests := []struct {
+		sym, data string
+		ok        bool
+	}{
+		{SymEAN13, "590123412345", true},
+		{SymEAN13, "5901234123457", true},
+		{SymEAN13, "5901234123458", false},
+		{SymEAN13, "ABC", false},
+		{SymEAN13, "12345", false},
+		{SymCode39, "ITEM-42 $/+%", true},
+		{SymCode39, "café", false},
+		{SymCode128, "abc\x01", false},
+		{"", "any ASCII ~", true},
+		{SymQR, "https://example.com/ünïcode", true},
+	}
+	for _, tt := range tests {
+		if err := ValidateData(tt.sym, tt.data); (err == nil) != tt.ok {
+			t.Errorf("ValidateData(%s, %q) = %v, want ok %v", tt.sym, tt.data, err, tt.ok)
+		}
+	}
+}
+
+func TestAdjustDowngrade(t *testing.T) {
+	caps := Capabilities{Model: "test", MaxWidthMM: 80, DPI: 203, Symbologies: []string{SymCode128}}
+
+	l := Label{SizeX: 80, Symbology: SymQR, BarcodeData: "ORDER-1234"}
+	warnings, err := caps.Adjust(&l)
+	if err != nil || l.Symbology != SymCode128 || len(warnings) != 1 {
+		t.Errorf("short data: symbology %s, warnings %v, err %v; want CODE128 with one warning", l.Symbology, warnings, err)
+	}
+
+	l = Label{SizeX: 80, Symbology: SymQR, BarcodeData: strings.Repeat("x", 100)}
+	if _, err := caps.Adjust(&l); err == nil {
+		t.Error("100 chars downgraded to CODE128 on an 80 mm label, want an error")
+	}
+
+	l = Label{SizeX: 80, Symbology: SymQR, BarcodeData: "ünïcode"}
+	if _, err := caps.Adjust(&l); err == nil {
+		t.Error("non-ASCII data downgraded to CODE128, want an error")
+	}
+}
@@ -0,0 +1,155 @@
+package tsplprinter
+
+import "strings"
+
+// Element kinds.
+const (
+	ElemText    = "text"
+	ElemBarcode = "barcode"
+	ElemRefCode = "refcode"
+)
+
+// Element is one thing drawn on a label, positioned in printer dots. W and H
+// are the approximate footprint, used for previews.
+type Element struct {
+	Kind      string `json:"kind"`
+	X         int    `json:"x"`
+	Y         int    `json:"y"`
+	W         int    `json:"w"`
+	H         int    `json:"h"`
+	Font      string `json:"font,omitempty"`      // TSPL font of text elements
+	Symbology string `json:"symbology,omitempty"` // barcode elements only
+	Content   string `json:"content"`
+}
+
+// Layout is a label's canvas size and elements, in printer dots.
+type Layout struct {
+	DotsPerMM  int       `json:"dotsPerMm"`
+	WidthDots  int       `json:"widthDots"`
+	HeightDots int       `json:"heightDots"`
+	Elements   []Element `json:"elements"`
+}
+
+// Font cell sizes in dots, per TSPL font name.
+var fontCells = map[string][2]int{"1": {8, 12}, "2": {12, 20}}
+
+// dotsPerMM converts a print head resolution to dots per mm; 0 means 203 dpi.
+func dotsPerMM(dpi int) int {
+	if dpi == 0 {
+		dpi = 203
+	}
+	return (dpi*10 + 127) / 254 // 203 dpi ~8 dots/mm
+}
+
+// code128Width is the width in dots of n characters of CODE128 at narrow bar
+// width 2: 11 modules per character plus start, check and stop.
+func code128Width(n int) int {
+	return (11*(n+3) + 2) * 2
+}
+
+// LabelLayout positions the label's elements the same way RenderLabel prints them.
+func LabelLayout(l Label) Layout {
+	dotsPerMM := dotsPerMM(l.DPI)
+
+	// Calculate positioning in dots
+	lay := Layout{DotsPerMM: dotsPerMM, WidthDots: l.SizeX * dotsPerMM, HeightDots: l.SizeY * dotsPerMM}
+	barcodeHeight := 80 // fixed height in dots
+	textHeight := 12    // approx font 2 height
+	spacing := 10       // dots between text and barcode
+	totalBlock := textHeight + barcodeHeight + spacing
+	yOffset := (lay.HeightDots - totalBlock) / 2
+	barcodeY := yOffset + textHeight + spacing
+
+	lay.Elements = append(lay.Elements, Element{
+		Kind: ElemText, X: 15, Y: yOffset,
+		W: len(l.TopText) * fontCells["2"][0], H: fontCells["2"][1],
+		Font: "2", Content: l.TopText,
+	})
+
+	sym := strings.ToUpper(l.Symbology)
+	if sym == "" {
+		sym = SymCode128
+	}
+	bc := Element{Kind: ElemBarcode, X: 0, Y: barcodeY, H: barcodeHeight, Symbology: sym, Content: l.BarcodeData}
+	n := len(l.BarcodeData)
+	switch sym {
+	case SymQR:
+		bc.X = 15
+		// Cell width 4; version grows roughly every 20 chars at ECC level M
+		bc.W = 4 * (21 + 4*(n/20))
+		bc.H = bc.W
+	case SymPDF417:
+		bc.X = 15
+		bc.W = lay.WidthDots - 30
+	case SymEAN13:
+		bc.W = 95 * 2
+	case SymCode39:
+		bc.W = 16 * (n + 2) * 2
+	default:
+		bc.W = code128Width(n)
+	}
+	lay.Elements = append(lay.Elements, bc)
+
+	if l.RefCode != "" {
+		if ref, ok := placeRefCode(lay, l.RefCode); ok {
+			lay.Elements = append(lay.Elements, ref)
+		}
+	}
+	return lay
+}
+
+// humanReadableHeight is the text line printed under 1D barcodes.
+const humanReadableHeight = 24
+
+// placeRefCode puts the ref code in the bottom-right corner, else right of
+// the barcode, else in the top-right corner: the first spot that stays on the
+// label and clear of the other elements. ok is false if none is.
+func placeRefCode(lay Layout, code string) (Element, bool) {
+	cell := fontCells["1"]
+	ref := Element{Kind: ElemRefCode, W: len(code) * cell[0], H: cell[1], Font: "1", Content: code}
+	ref.X = lay.WidthDots - ref.W - 10
+
+	var bc Element
+	for _, el := range lay.Elements {
+		if el.Kind == ElemBarcode {
+			bc = el
+		}
+	}
+	for _, y := range []int{lay.HeightDots - 20, bc.Y, 4} {
+		ref.Y = y
+		if ref.X >= 0 && ref.Y >= 0 && ref.Y+ref.H <= lay.HeightDots && !overlapsAny(ref, lay.Elements) {
+			return ref, true
+		}
+	}
+	return Element{}, false
+}
+
+// RefCodeFits reports whether the label's ref code has a free spot, see
+// placeRefCode. Labels without one are printed without it.
+func RefCodeFits(l Label) bool {
+	for _, el := range LabelLayout(l).Elements {
+		if el.Kind == ElemRefCode {
+			return true
+		}
+	}
+	return l.RefCode == ""
+}
+
+// overlapsAny reports whether el comes within a few dots of any of others.
+func overlapsAny(el Element, others []Element) bool {
+	const margin = 4
+	for _, o := range others {
+		h := o.H
+		if o.Kind == ElemBarcode && o.Symbology != SymQR && o.Symbology != SymPDF417 {
+			h += humanReadableHeight
+		}
+		if o.W == 0 || h == 0 {
+			continue
+		}
+		if el.X < o.X+o.W+margin && o.X < el.X+el.W+margin &&
+			el.Y < o.Y+h+margin && o.Y < el.Y+el.H+margin {
+			return true
+		}
+	}
+	return false
+}
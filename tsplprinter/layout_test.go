@@ -0,0 +1,40 @@
+package tsplprinter
+
+import "testing"
+
+func TestRefCodeClearOfBarcode(t *testing.T) {
+	tests := []struct {
+		name  string
+		label Label
+		fits  bool
+	}{
+		{"tall label, bottom-right", Label{SizeX: 45, SizeY: 35, TopText: "Bananas", BarcodeData: "4006381333931"}, true},
+		{"short label, top-right", Label{SizeX: 45, SizeY: 15, TopText: "Organic Bananas 1kg", BarcodeData: "4006381333931"}, true},
+		{"short wide label, right of barcode", Label{SizeX: 80, SizeY: 15, TopText: "Organic Bananas 1kg", BarcodeData: "4006381333931"}, true},
+		{"short label, no room", Label{SizeX: 45, SizeY: 15, TopText: "Organic Fairtrade Bananas", BarcodeData: "4006381333931"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.label.RefCode = "7KQ2MX"
+			lay := LabelLayout(tt.label)
+			var ref *Element
+			for i, el := range lay.Elements {
+				if el.Kind == ElemRefCode {
+					ref = &lay.Elements[i]
+				}
+			}
+			if (ref != nil) != tt.fits || RefCodeFits(tt.label) != tt.fits {
+				t.Fatalf("ref code placed: %v, want %v", ref != nil, tt.fits)
+			}
+			if ref == nil {
+				return
+			}
+			if ref.X < 0 || ref.Y < 0 || ref.X+ref.W > lay.WidthDots || ref.Y+ref.H > lay.HeightDots {
+				t.Errorf("ref code %+v off the %dx%d label", *ref, lay.WidthDots, lay.HeightDots)
+			}
+			if overlapsAny(*ref, lay.Elements[:len(lay.Elements)-1]) {
+				t.Errorf("ref code %+v overlaps %+v", *ref, lay.Elements)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package tsplprinter
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SimVID is the vendor ID of simulated printers. 0xffff is never assigned to
+// a real USB vendor.
+const SimVID = 0xffff
+
+// SimPrinter stands in for a USB printer in load tests. It accepts whatever
+// it is sent, taking LabelTime per printed label, and only one session at a
+// time, like a claimed USB interface.
+type SimPrinter struct {
+	LabelTime time.Duration
+
+	mu     sync.Mutex // held for the length of a session
+	Bytes  atomic.Int64
+	Labels atomic.Int64
+}
+
+var (
+	simMu       sync.RWMutex
+	simPrinters = map[uint16]*SimPrinter{}
+)
+
+// AddSimPrinters registers n simulated printers and returns their product
+// IDs as hex strings; their vendor ID is SimVID.
+func AddSimPrinters(n int, labelTime time.Duration) []string {
+	simMu.Lock()
+	defer simMu.Unlock()
+	var pids []string
+	for i := 0; i < n; i++ {
+		pid := uint16(len(simPrinters) + 1)
+		simPrinters[pid] = &SimPrinter{LabelTime: labelTime}
+		pids = append(pids, fmt.Sprintf("0x%04x", pid))
+	}
+	return pids
+}
+
+// LookupSimPrinter returns the simulated printer with the given IDs, if any.
+func LookupSimPrinter(vidHexStr, pidHexStr string) (*SimPrinter, bool) {
+	vid, pid, err := parseIDs(vidHexStr, pidHexStr)
+	if err != nil || vid != SimVID {
+		return nil, false
+	}
+	simMu.RLock()
+	defer simMu.RUnlock()
+	p, ok := simPrinters[uint16(pid)]
+	return p, ok
+}
+
+// Write implements io.Writer, sleeping for the labels each PRINT command feeds.
+func (p *SimPrinter) Write(data []byte) (int, error) {
+	p.Bytes.Add(int64(len(data)))
+	labels := 0
+	for _, line := range bytes.Split(data, []byte("\r\n")) {
+		args, ok := bytes.CutPrefix(line, []byte("PRINT "))
+		if !ok {
+			continue
+		}
+		copies, _, _ := bytes.Cut(args, []byte(","))
+		if n, err := strconv.Atoi(string(copies)); err == nil {
+			labels += n
+		}
+	}
+	if labels > 0 {
+		p.Labels.Add(int64(labels))
+		time.Sleep(time.Duration(labels) * p.LabelTime)
+	}
+	return len(data), nil
+}
+
+func (p *SimPrinter) open() *printerConn {
+	p.mu.Lock()
+	return &printerConn{ep: p, release: p.mu.Unlock}
+}
@@ -2,11 +2,81 @@ package tsplprinter
 
 import (
 	"fmt"
+	"io"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/gousb"
 )
 
+const (
+	// PipelineDepth is how many rendered labels may be queued for the USB transfer
+	// while the next one is being rendered (double buffering).
+	PipelineDepth = 2
+
+	// ChunkSize is the largest single USB bulk write; bigger payloads are split.
+	ChunkSize = 4096
+	// MaxChunkRetries is how many times a failing chunk is retried before the
+	// transfer is abandoned.
+	MaxChunkRetries = 3
+	ChunkRetryDelay = 200 * time.Millisecond
+
+	// GapMM is the gap between labels on the roll.
+	GapMM = 2
+
+	// MaxDensity is the darkest TSPL print density.
+	MaxDensity = 15
+)
+
+// Label is a single TSPL barcode label and the number of copies to print.
+type Label struct {
+	SizeX       int // label width in mm
+	SizeY       int // label height in mm
+	Direction   int // print direction (0-3)
+	TopText     string
+	BarcodeData string
+	Symbology   string // one of Symbologies, "" means CODE128
+	Copies      int
+	Speed       int    // print speed in ips, 0 keeps the printer setting
+	Density     int    // print darkness 1-15, 0 keeps the printer setting
+	DPI         int    // print head resolution, 0 means 203 dpi
+	Cut         bool   // cut after the run
+	RefCode     string // tiny job reference printed in a free corner, see placeRefCode
+}
+
+// printerConn holds the open USB handles for a printer. Simulated printers
+// only set ep and release.
+type printerConn struct {
+	ctx     *gousb.Context
+	dev     *gousb.Device
+	cfg     *gousb.Config
+	intf    *gousb.Interface
+	ep      io.Writer
+	release func()
+	unlock  func() // ends the session, see sessionLock
+}
+
+var (
+	sessionsMu sync.Mutex
+	sessions   = map[[2]gousb.ID]*sync.Mutex{}
+)
+
+// sessionLock returns the lock held for the length of a USB session with a
+// printer, so prints and alerts from different goroutines take turns rather
+// than fail to claim the interface.
+func sessionLock(vid, pid gousb.ID) *sync.Mutex {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	mu, ok := sessions[[2]gousb.ID{vid, pid}]
+	if !ok {
+		mu = &sync.Mutex{}
+		sessions[[2]gousb.ID{vid, pid}] = mu
+	}
+	return mu
+}
+
 // printBarcodeLabelTspl opens the USB device, claims the endpoint, and sends a TSPL barcode label.
 // vidHexStr, pidHexStr: USB Vendor and Product IDs as hex strings (e.g., "0x0fe6")
 // sizeX, sizeY: label dimensions in mm
@@ -14,104 +84,238 @@ import (
 // topText: human-readable text above the barcode
 // barcodeData: the data to encode in the barcode
 func PrintBarcodeLabelTspl(vidHexStr, pidHexStr string, sizeX, sizeY, dir int, topText, barcodeData string, printCount int) error {
-	// Parse hex strings to uint16
-	vid64, err := strconv.ParseUint(vidHexStr, 0, 16)
+	return PrintLabels(vidHexStr, pidHexStr, []Label{{
+		SizeX:       sizeX,
+		SizeY:       sizeY,
+		Direction:   dir,
+		TopText:     topText,
+		BarcodeData: barcodeData,
+		Copies:      printCount,
+		Cut:         true,
+	}})
+}
+
+// PrintLabels sends a run of labels to the printer over a single USB session.
+// Rendering runs in its own goroutine, PipelineDepth labels ahead of the
+// transfer, so the next command buffer is ready as soon as the previous write
+// completes. The first write error stops the run.
+func PrintLabels(vidHexStr, pidHexStr string, labels []Label) error {
+	conn, err := openPrinter(vidHexStr, pidHexStr)
 	if err != nil {
-		return fmt.Errorf("invalid Vendor ID %q: %w", vidHexStr, err)
+		return err
 	}
-	pid64, err := strconv.ParseUint(pidHexStr, 0, 16)
+	defer conn.Close()
+	return WriteLabels(conn.ep, labels)
+}
+
+// WriteLabels renders labels and writes them to w the way PrintLabels sends
+// them to a printer, e.g. to capture a run in a file.
+func WriteLabels(w io.Writer, labels []Label) error {
+	bufs := make(chan []byte, PipelineDepth)
+	done := make(chan struct{})
+	defer close(done)
+
+	// Render ahead of the transfer
+	go func() {
+		defer close(bufs)
+		for _, l := range labels {
+			select {
+			case bufs <- renderCached(l):
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// Send labels to printer as they become ready
+	n := 0
+	for buf := range bufs {
+		n++
+		if err := writeChunked(w, buf); err != nil {
+			return fmt.Errorf("failed to write TSPL data for label %d/%d: %w", n, len(labels), err)
+		}
+	}
+	return nil
+}
+
+// PrintRaw sends a ready-made printer program (TSPL, or ZPL for printers with
+// ZPL emulation) copies times over a single USB session.
+func PrintRaw(vidHexStr, pidHexStr string, data []byte, copies int) error {
+	conn, err := openPrinter(vidHexStr, pidHexStr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for i := 1; i <= copies; i++ {
+		if err := writeChunked(conn.ep, data); err != nil {
+			return fmt.Errorf("failed to write raw data for copy %d/%d: %w", i, copies, err)
+		}
+	}
+	return nil
+}
+
+// writeChunked writes data in ChunkSize pieces, checking each write for errors
+// and short writes. A failed chunk is retried from the last byte the endpoint
+// accepted, so a flaky cable does not restart the whole label.
+func writeChunked(w io.Writer, data []byte) error {
+	off, retries := 0, 0
+	for off < len(data) {
+		end := min(off+ChunkSize, len(data))
+		n, err := w.Write(data[off:end])
+		off += n
+		if err == nil && off == end {
+			retries = 0
+			continue
+		}
+		if err == nil {
+			err = io.ErrShortWrite
+		}
+		retries++
+		if retries > MaxChunkRetries {
+			return fmt.Errorf("chunk at byte %d/%d failed after %d retries: %w", off, len(data), MaxChunkRetries, err)
+		}
+		time.Sleep(ChunkRetryDelay)
+	}
+	return nil
+}
+
+// RenderLabel builds the TSPL command buffer for a label.
+func RenderLabel(l Label) []byte {
+	lay := LabelLayout(l)
+
+	// Build TSPL command string
+	var b strings.Builder
+	fmt.Fprintf(&b, "SIZE %d mm, %d mm\r\n", l.SizeX, l.SizeY)
+	fmt.Fprintf(&b, "GAP %d mm, 0 mm\r\n", GapMM)
+	if l.Speed > 0 {
+		fmt.Fprintf(&b, "SPEED %d\r\n", l.Speed)
+	}
+	if l.Density > 0 {
+		fmt.Fprintf(&b, "DENSITY %d\r\n", l.Density)
+	}
+	fmt.Fprintf(&b, "DIRECTION %d\r\n", l.Direction)
+	b.WriteString("CLS\r\n")
+	b.WriteString("SET PRINTER DT\r\n")
+	for _, el := range lay.Elements {
+		writeElement(&b, el)
+	}
+	fmt.Fprintf(&b, "PRINT %d,1\r\n", l.Copies)
+	if l.Cut {
+		b.WriteString("CUT\r\n")
+	}
+	return []byte(b.String())
+}
+
+// writeElement writes the TSPL command drawing one layout element.
+func writeElement(b *strings.Builder, el Element) {
+	switch {
+	case el.Kind != ElemBarcode:
+		fmt.Fprintf(b, "TEXT %d,%d,\"%s\",0,1,1,\"%s\"\r\n", el.X, el.Y, el.Font, el.Content)
+	case el.Symbology == SymQR:
+		fmt.Fprintf(b, "QRCODE %d,%d,M,4,A,0,\"%s\"\r\n", el.X, el.Y, el.Content)
+	case el.Symbology == SymPDF417:
+		fmt.Fprintf(b, "PDF417 %d,%d,%d,%d,0,\"%s\"\r\n", el.X, el.Y, el.W, el.H, el.Content)
+	default:
+		fmt.Fprintf(b, "BARCODE %d,%d,\"%s\",%d,1,0,2,2,\"%s\"\r\n", el.X, el.Y, el.Symbology, el.H, el.Content)
+	}
+}
+
+// openPrinter opens the USB device, claims interface 0 and its OUT endpoint.
+func openPrinter(vidHexStr, pidHexStr string) (*printerConn, error) {
+	if sim, ok := LookupSimPrinter(vidHexStr, pidHexStr); ok {
+		return sim.open(), nil
+	}
+	vid, pid, err := parseIDs(vidHexStr, pidHexStr)
 	if err != nil {
-		return fmt.Errorf("invalid Product ID %q: %w", pidHexStr, err)
+		return nil, err
 	}
-	vid := gousb.ID(uint16(vid64))
-	pid := gousb.ID(uint16(pid64))
+
+	mu := sessionLock(vid, pid)
+	mu.Lock()
 
 	// Create USB context
-	ctx := gousb.NewContext()
-	defer ctx.Close()
+	conn := &printerConn{ctx: gousb.NewContext(), unlock: mu.Unlock}
 
 	// Open device
-	dev, err := ctx.OpenDeviceWithVIDPID(vid, pid)
+	conn.dev, err = conn.ctx.OpenDeviceWithVIDPID(vid, pid)
 	if err != nil {
-		return fmt.Errorf("could not open device %04x:%04x: %w", vid, pid, err)
+		conn.Close()
+		return nil, fmt.Errorf("could not open device %04x:%04x: %w", vid, pid, err)
 	}
-	if dev == nil {
-		return fmt.Errorf("printer %04x:%04x not found", vid, pid)
+	if conn.dev == nil {
+		conn.Close()
+		return nil, fmt.Errorf("printer %04x:%04x not found", vid, pid)
 	}
-	defer dev.Close()
 
 	// Detach kernel driver if needed
-	dev.SetAutoDetach(true)
+	conn.dev.SetAutoDetach(true)
 
 	// Set configuration and claim interface
-	cfg, err := dev.Config(1)
+	conn.cfg, err = conn.dev.Config(1)
 	if err != nil {
-		return fmt.Errorf("could not set config: %w", err)
+		conn.Close()
+		return nil, fmt.Errorf("could not set config: %w", err)
 	}
-	defer cfg.Close()
 
-	intf, err := cfg.Interface(0, 0)
+	conn.intf, err = conn.cfg.Interface(0, 0)
 	if err != nil {
-		return fmt.Errorf("could not claim interface: %w", err)
+		conn.Close()
+		return nil, fmt.Errorf("could not claim interface: %w", err)
 	}
-	defer intf.Close()
 
 	// Open OUT endpoint
-	ep, err := intf.OutEndpoint(1)
+	conn.ep, err = conn.intf.OutEndpoint(1)
 	if err != nil {
-		return fmt.Errorf("could not open endpoint: %w", err)
+		conn.Close()
+		return nil, fmt.Errorf("could not open endpoint: %w", err)
 	}
+	return conn, nil
+}
 
-	// Calculate positioning in dots (203 dpi ~8 dots/mm)
-	heightDots := sizeY * 8
-	barcodeHeight := 80 // fixed height in dots
-	textHeight := 12    // approx font 2 height
-	spacing := 10       // dots between text and barcode
-	totalBlock := textHeight + barcodeHeight + spacing
-	yOffset := (heightDots - totalBlock) / 2
-
-	// Build TSPL command string
-	label := fmt.Sprintf(
-		"SIZE %d mm, %d mm\r\n"+
-			"GAP 2 mm, 0 mm\r\n"+
-			"DIRECTION %d\r\n"+
-			"CLS\r\n"+
-			"SET PRINTER DT\r\n"+
-			"TEXT 15,%d,\"2\",0,1,1,\"%s\"\r\n"+
-			"BARCODE 0,%d,\"128\",%d,1,0,2,2,\"%s\"\r\n"+
-			"PRINT %d,1\r\n"+
-			"CUT\r\n",
-		sizeX,
-		sizeY,
-		dir,
-		yOffset,
-		topText,
-		yOffset+textHeight+spacing,
-		barcodeHeight,
-		barcodeData,
-		printCount,
-	)
-
-	// Send label to printer
-	if _, err := ep.Write([]byte(label)); err != nil {
-		return fmt.Errorf("failed to write TSPL data: %w", err)
+// Close releases the USB handles in reverse order of acquisition.
+func (c *printerConn) Close() {
+	if c.release != nil {
+		c.release()
+		return
 	}
-	return nil
+	if c.intf != nil {
+		c.intf.Close()
+	}
+	if c.cfg != nil {
+		c.cfg.Close()
+	}
+	if c.dev != nil {
+		c.dev.Close()
+	}
+	c.ctx.Close()
+	c.unlock()
 }
 
-// CheckPrinter tries to open (and immediately close) the USB device to verify it exists.
-func CheckPrinterDevice(vidHexStr, pidHexStr string) error {
-	// Parse hex strings
+// parseIDs parses USB Vendor and Product IDs given as hex strings.
+func parseIDs(vidHexStr, pidHexStr string) (gousb.ID, gousb.ID, error) {
 	vid64, err := strconv.ParseUint(vidHexStr, 0, 16)
 	if err != nil {
-		return fmt.Errorf("invalid Vendor ID %q: %w", vidHexStr, err)
+		return 0, 0, fmt.Errorf("invalid Vendor ID %q: %w", vidHexStr, err)
 	}
 	pid64, err := strconv.ParseUint(pidHexStr, 0, 16)
 	if err != nil {
-		return fmt.Errorf("invalid Product ID %q: %w", pidHexStr, err)
+		return 0, 0, fmt.Errorf("invalid Product ID %q: %w", pidHexStr, err)
+	}
+	return gousb.ID(uint16(vid64)), gousb.ID(uint16(pid64)), nil
+}
+
+// CheckPrinter tries to open (and immediately close) the USB device to verify it exists.
+func CheckPrinterDevice(vidHexStr, pidHexStr string) error {
+	if _, ok := LookupSimPrinter(vidHexStr, pidHexStr); ok {
+		return nil
+	}
+
+	// Parse hex strings
+	vid, pid, err := parseIDs(vidHexStr, pidHexStr)
+	if err != nil {
+		return err
 	}
-	vid := gousb.ID(uint16(vid64))
-	pid := gousb.ID(uint16(pid64))
 
 	ctx := gousb.NewContext()
 	defer ctx.Close()
@@ -0,0 +1,110 @@
+package tsplprinter
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// step scripts one Write call: accept at most n bytes, then return err.
+type step struct {
+	n   int
+	err error
+}
+
+// flakyWriter plays back steps, then accepts everything.
+type flakyWriter struct {
+	steps []step
+	got   bytes.Buffer
+	calls int
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	w.calls++
+	if len(p) > ChunkSize {
+		return 0, errors.New("write larger than ChunkSize")
+	}
+	if len(w.steps) == 0 {
+		return w.got.Write(p)
+	}
+	s := w.steps[0]
+	w.steps = w.steps[1:]
+	n := min(s.n, len(p))
+	w.got.Write(p[:n])
+	return n, s.err
+}
+
+func TestWriteChunked(t *testing.T) {
+	errUSB := errors.New("usb: timeout")
+	data := make([]byte, 2*ChunkSize+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	tests := []struct {
+		name      string
+		data      []byte
+		steps     []step
+		wantErr   error
+		wantCalls int
+	}{
+		{name: "empty", data: nil, wantCalls: 0},
+		{name: "one chunk", data: data[:100], wantCalls: 1},
+		{name: "split into chunks", data: data, wantCalls: 3},
+		{
+			name:      "short write resumes at last accepted byte",
+			data:      data,
+			steps:     []step{{n: 1000}},
+			wantCalls: 3, // 1000, then ChunkSize from byte 1000, then the rest
+		},
+		{
+			name:      "error after partial write resumes",
+			data:      data,
+			steps:     []step{{n: ChunkSize}, {n: 10, err: errUSB}},
+			wantCalls: 4,
+		},
+		{
+			name:      "retries reset after a good chunk",
+			data:      data,
+			steps:     []step{{err: errUSB}, {err: errUSB}, {n: ChunkSize}, {err: errUSB}, {err: errUSB}},
+			wantCalls: 7,
+		},
+		{
+			name:    "gives up after MaxChunkRetries",
+			data:    data,
+			steps:   []step{{err: errUSB}, {err: errUSB}, {err: errUSB}, {err: errUSB}},
+			wantErr: errUSB,
+		},
+		{
+			name:    "zero-byte writes count as short",
+			data:    data[:10],
+			steps:   []step{{}, {}, {}, {}},
+			wantErr: io.ErrShortWrite,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &flakyWriter{steps: tt.steps}
+			err := writeChunked(w, tt.data)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				if w.calls != MaxChunkRetries+1 {
+					t.Errorf("calls = %d, want %d", w.calls, MaxChunkRetries+1)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(w.got.Bytes(), tt.data) {
+				t.Errorf("printer received %d bytes, differing from the %d sent", w.got.Len(), len(tt.data))
+			}
+			if w.calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", w.calls, tt.wantCalls)
+			}
+		})
+	}
+}